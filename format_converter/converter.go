@@ -3,49 +3,132 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/CQUST-Runner/datacross/storage"
 )
 
+// outputFormat returns the format named to, the default is json for bin input and bin otherwise
+func outputFormat(to string, input storage.LogFormat, crypt *storage.CryptLog) (storage.LogFormat, error) {
+	if to == "" {
+		if input != nil && input.ID() == storage.BinLogFormat {
+			return &storage.JsonLog{}, nil
+		}
+		return &storage.BinLog{}, nil
+	}
+	of, err := storage.LogFormatByName(to)
+	if err != nil {
+		return nil, err
+	}
+	if of.ID() == storage.CryptLogFormat {
+		if crypt == nil {
+			return nil, fmt.Errorf("passphrase or keyfile is required by aes format")
+		}
+		return crypt, nil
+	}
+	return of, nil
+}
+
+func printResult(name string, r *storage.ConvertResult) {
+	fmt.Printf("%v: %v segments converted, %v skipped, %v entries, verified %v\n",
+		name, r.Segments, r.Skipped, r.Entries, r.Verified)
+}
+
 func main() {
-	input := flag.String("input", "", "")
-	format := flag.String("format", "", "")
-	output := flag.String("output", "", "")
+	input := flag.String("input", "", "wal to convert")
+	output := flag.String("output", "", "wal to write, with -verify it's only compared with input if it exists")
+	wd := flag.String("wd", "", "working directory, wal of every participant in it is converted in place")
+	format := flag.String("format", "", "format of input, detected if empty")
+	to := flag.String("to", "", "format of output: bin, json, flate or aes, defaults to json for bin input and bin otherwise")
+	passphrase := flag.String("passphrase", "", "secret of aes format, used by both input and output")
+	keyFile := flag.String("keyfile", "", "file containing secret of aes format, used by both input and output")
+	verify := flag.Bool("verify", false, "check that output decodes to the identical operations")
 	flag.Parse()
 
-	fmt.Println(*input, *format, *output)
-
 	var iff storage.LogFormat
-	var of storage.LogFormat
+	var err error
 
-	if *format == "json" {
-		iff = &storage.JsonLog{}
-		of = &storage.BinLog{}
-	} else {
-		iff = &storage.BinLog{}
-		of = &storage.JsonLog{}
+	var crypt *storage.CryptLog
+	if *passphrase != "" || *keyFile != "" {
+		crypt = &storage.CryptLog{}
+		if *keyFile != "" {
+			err = crypt.InitWithKeyFile(*keyFile)
+		} else {
+			err = crypt.InitWithPassphrase(*passphrase)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		// preferred if the input is encrypted
+		iff = crypt
+	}
+
+	if *format != "" {
+		iff, err = storage.LogFormatByName(*format)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if iff.ID() == storage.CryptLogFormat && crypt != nil {
+			iff = crypt
+		}
+	}
+
+	if *wd != "" {
+		if *to == "" {
+			fmt.Println("-to is required to convert a working directory")
+			os.Exit(1)
+		}
+		of, err := outputFormat(*to, iff, crypt)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		results, err := storage.ConvertWorkingDirectory(*wd, iff, of, *verify)
+		for name, r := range results {
+			printResult(name, r)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *input == "" || *output == "" {
+		fmt.Println("-input and -output, or -wd is required")
+		os.Exit(1)
 	}
 
 	wi := storage.Wal{}
 	if err := wi.Init(*input, iff, true); err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
-	defer wi.Close()
-	wo := storage.Wal{}
-	if err := wo.Init(*output, of, false); err != nil {
+	detected := wi.Format()
+	wi.Close()
+
+	of, err := outputFormat(*to, detected, crypt)
+	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
-	defer wo.Close()
 
-	// TODO use per entry iteration
-	it := wi.Iterator()
-	for it.Next() {
-		err := wo.AppendRaw(it.LogOp())
+	if *verify && storage.IsFile(*output) {
+		err := storage.VerifyWal(*input, *output, detected, of)
 		if err != nil {
-			fmt.Println(err)
-			return
+			fmt.Println("verify failed", err)
+			os.Exit(1)
 		}
+		fmt.Println("verified")
+		return
+	}
+
+	r, err := storage.ConvertWal(*input, *output, detected, of, *verify)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
+	printResult(*output, r)
 }
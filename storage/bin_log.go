@@ -6,14 +6,82 @@ import (
 	"hash/crc32"
 	"io"
 	math "math"
+	"runtime/debug"
 )
 
+// entries larger than it are checked against file size before being read
+const largeEntrySize = 1 << 20
+
+const binLogVersion = 1
+
 type BinLog struct {
 }
 
+func (l *BinLog) ID() LogFormatID {
+	return BinLogFormat
+}
+
+func (l *BinLog) Name() string {
+	return "bin"
+}
+
 // 写失败将破坏文件数据
 func (l *BinLog) WriteHeader(f File, header *FileHeader) error {
-	_, err := f.Seek(0, io.SeekStart)
+	return writeBinHeader(f, header, l.ID(), binLogVersion)
+}
+
+// IsValidFile checks if f has a header written by BinLog
+func (l *BinLog) IsValidFile(f File) (bool, error) {
+	has, err := hasHeader(f)
+	if err != nil || !has {
+		return false, err
+	}
+	_, err = l.ReadHeader(f)
+	return err == nil, nil
+}
+
+func (l *BinLog) ReadHeader(f File) (*FileHeader, error) {
+	return readBinHeader(f, l.ID(), binLogVersion)
+}
+
+// 写失败将破坏文件数据
+func (l *BinLog) AppendEntry(f File, pos int64, entry *LogEntry) (int64, error) {
+	sz := entry.Size()
+	if sz == 0 {
+		return 0, nil
+	}
+	data := make([]byte, sz)
+	_, err := entry.MarshalToSizedBuffer(data)
+	if err != nil {
+		return 0, err
+	}
+	return appendBinFrame(f, pos, data)
+}
+
+func (l *BinLog) ReadEntry(f File, pos int64, entry *LogEntry) (_ int64, err error) {
+	defer recoverFault(&err, debug.SetPanicOnFault(true))
+	data, n, err := readBinFrame(f, pos)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		entry.Reset()
+		return n, nil
+	}
+	err = entry.Unmarshal(data)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// header layout shared by binary formats: size(4) + header
+func writeBinHeader(f File, header *FileHeader, format LogFormatID, version int32) error {
+	header, err := sealHeader(header, format, version)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
 	if err != nil {
 		return err
 	}
@@ -42,24 +110,12 @@ func (l *BinLog) WriteHeader(f File, header *FileHeader) error {
 	return nil
 }
 
-// TODO: 更多校验
-func (l *BinLog) IsValidFile(f File) (bool, error) {
-	fSize, err := f.Seek(0, io.SeekEnd)
-	if err != nil {
-		return false, err
-	}
-	if fSize < HeaderSize {
-		return false, nil
-	}
-	return true, nil
-}
-
-func (l *BinLog) ReadHeader(f File) (*FileHeader, error) {
-	valid, err := l.IsValidFile(f)
+func readBinHeader(f File, format LogFormatID, version int32) (*FileHeader, error) {
+	has, err := hasHeader(f)
 	if err != nil {
 		return nil, err
 	}
-	if !valid {
+	if !has {
 		return nil, fmt.Errorf("invalid file")
 	}
 
@@ -78,7 +134,11 @@ func (l *BinLog) ReadHeader(f File) (*FileHeader, error) {
 
 	headerDataSize := binary.LittleEndian.Uint32(headerBuffer[:4])
 	if headerDataSize == 0 {
-		return &FileHeader{}, nil
+		header := FileHeader{}
+		if err := checkHeader(&header, format, version); err != nil {
+			return nil, err
+		}
+		return &header, nil
 	}
 	if int(headerDataSize) > len(headerBuffer)-4 {
 		return nil, fmt.Errorf("invalid file")
@@ -89,20 +149,25 @@ func (l *BinLog) ReadHeader(f File) (*FileHeader, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = checkHeader(&header, format, version)
+	if err != nil {
+		return nil, err
+	}
 	return &header, nil
 }
 
+// entry layout shared by binary formats: size(4) + data + crc(4) of data
 // 写失败将破坏文件数据
-func (l *BinLog) AppendEntry(f File, pos int64, entry *LogEntry) (int64, error) {
-	valid, err := l.IsValidFile(f)
+func appendBinFrame(f File, pos int64, data []byte) (int64, error) {
+	has, err := hasHeader(f)
 	if err != nil {
 		return 0, err
 	}
-	if !valid {
+	if !has {
 		return 0, fmt.Errorf("invalid file")
 	}
 
-	sz := entry.Size()
+	sz := len(data)
 	if sz == 0 {
 		return 0, nil
 	}
@@ -127,10 +192,7 @@ func (l *BinLog) AppendEntry(f File, pos int64, entry *LogEntry) (int64, error)
 		return 0, fmt.Errorf("log entry too large")
 	}
 	entryBuffer := make([]byte, writeSize)
-	_, err = entry.MarshalToSizedBuffer(entryBuffer[4 : 4+sz])
-	if err != nil {
-		return 0, err
-	}
+	copy(entryBuffer[4:4+sz], data)
 	binary.LittleEndian.PutUint32(entryBuffer[0:4], uint32(sz))
 	crcSum := crc32.ChecksumIEEE(entryBuffer[4 : len(entryBuffer)-4])
 	binary.LittleEndian.PutUint32(entryBuffer[len(entryBuffer)-4:], crcSum)
@@ -145,57 +207,99 @@ func (l *BinLog) AppendEntry(f File, pos int64, entry *LogEntry) (int64, error)
 	return int64(writeSz), nil
 }
 
-func (l *BinLog) ReadEntry(f File, pos int64, entry *LogEntry) (int64, error) {
+// readBinFrame returns data of the frame at pos and size of the frame,
+// data is nil for an empty frame, it may refer to content of f
+func readBinFrame(f File, pos int64) ([]byte, int64, error) {
+	if sr, ok := f.(sliceReader); ok {
+		return sliceBinFrame(sr, pos)
+	}
 	_, err := f.Seek(pos, io.SeekStart)
 	if err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 
 	sizeBuffer := [4]byte{}
 	readSz, err := f.Read(sizeBuffer[:])
 	if err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if readSz != len(sizeBuffer) {
-		return 0, fmt.Errorf("read size unexpected")
+		return nil, 0, fmt.Errorf("read size unexpected")
 	}
 
 	size := binary.LittleEndian.Uint32(sizeBuffer[:])
 	if size == 0 {
-		entry.Reset()
 		// size+crc
-		return 4 + 4, nil
+		return nil, 4 + 4, nil
+	}
+	// size read from a torn tail may be anything, don't allocate for it blindly
+	if size > largeEntrySize {
+		fileSize, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pos+int64(size)+8 > fileSize {
+			return nil, 0, fmt.Errorf("entry exceeds end of file pos[%v]", pos)
+		}
+		_, err = f.Seek(pos+4, io.SeekStart)
+		if err != nil {
+			return nil, 0, err
+		}
 	}
 
 	entryBuffer := make([]byte, size)
 	readSz, err = f.Read(entryBuffer)
 	if err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if readSz != len(entryBuffer) {
-		return 0, fmt.Errorf("read size unexpected")
+		return nil, 0, fmt.Errorf("read size unexpected")
 	}
 
 	crcSumBuffer := [4]byte{}
 	readSz, err = f.Read(crcSumBuffer[:])
 	if err != nil {
-		return 0, err
+		return nil, 0, err
 	}
 	if readSz != len(crcSumBuffer) {
-		return 0, fmt.Errorf("read size unexpected")
+		return nil, 0, fmt.Errorf("read size unexpected")
 	}
 
 	crcSumRead := binary.LittleEndian.Uint32(crcSumBuffer[:])
 	crcSumCalc := crc32.ChecksumIEEE(entryBuffer)
 	if crcSumCalc != crcSumRead {
-		return 0, fmt.Errorf("crc checksum mismatch pos[%v]", pos)
+		return nil, 0, fmt.Errorf("crc checksum mismatch pos[%v]", pos)
 	}
+	return entryBuffer, int64(size + 8), nil
+}
 
-	err = entry.Unmarshal(entryBuffer)
+// sliceBinFrame is readBinFrame without copying
+func sliceBinFrame(sr sliceReader, pos int64) ([]byte, int64, error) {
+	sizeBuffer, err := sr.slice(pos, 4)
 	if err != nil {
-		return 0, err
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint32(sizeBuffer)
+	if size == 0 {
+		// size+crc
+		return nil, 4 + 4, nil
+	}
+	frame, err := sr.slice(pos, int(size)+8)
+	if err != nil {
+		return nil, 0, fmt.Errorf("entry exceeds end of file pos[%v]", pos)
+	}
+
+	entryBuffer := frame[4 : 4+size]
+	crcSumRead := binary.LittleEndian.Uint32(frame[4+size:])
+	crcSumCalc := crc32.ChecksumIEEE(entryBuffer)
+	if crcSumCalc != crcSumRead {
+		return nil, 0, fmt.Errorf("crc checksum mismatch pos[%v]", pos)
 	}
-	return int64(size + 8), nil
+	return entryBuffer, int64(size + 8), nil
+}
+
+func init() {
+	RegisterLogFormat(&BinLog{})
 }
 
 func _() {
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// checkpointState is what has been applied in memory since the last checkpoint
+type checkpointState struct {
+	// leaves added, by gid
+	added map[string]bool
+	// leaves replaced, by gid, which may be in db
+	removed map[string]bool
+	// operations applied
+	ops  int64
+	last time.Time
+
+	interval time.Duration
+	entries  int64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (c *checkpointState) init(interval time.Duration, entries int64) {
+	c.added = make(map[string]bool)
+	c.removed = make(map[string]bool)
+	c.ops = 0
+	c.last = time.Now()
+	c.interval = interval
+	c.entries = entries
+}
+
+func (c *checkpointState) track(op *LogOperation, replaced bool) {
+	c.added[op.Gid] = true
+	if replaced {
+		if c.added[op.PrevGid] {
+			// never written
+			delete(c.added, op.PrevGid)
+		} else {
+			c.removed[op.PrevGid] = true
+		}
+	}
+	c.ops++
+}
+
+func (c *checkpointState) reset() {
+	c.added = make(map[string]bool)
+	c.removed = make(map[string]bool)
+	c.ops = 0
+	c.last = time.Now()
+}
+
+// checkpoint writes leaves changed since the last checkpoint and progress of all logs
+// to db in one transaction, they are kept to retry next time if it fails
+func (p *Participant) checkpoint() error {
+	removed := make([]string, 0, len(p.cp.removed))
+	for gid := range p.cp.removed {
+		removed = append(removed, gid)
+	}
+	records := make([]*DBRecord, 0, len(p.cp.added))
+	for gid := range p.cp.added {
+		record, err := p.ns.GetByGid(gid)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		copied := *record
+		records = append(records, &copied)
+	}
+	persisted := LogProgressMgr{}
+	persisted.Init()
+	progress := make([]*LogProgress, 0, len(p.m.m))
+	for name, pr := range p.m.m {
+		copied := *pr
+		copied.MachineID = name
+		persisted.Set(name, &copied)
+		written := copied
+		progress = append(progress, &written)
+	}
+
+	err := writeBatch(p.db, removed, records, progress)
+	if err != nil {
+		return err
+	}
+	logger.Info("checkpoint %v operations, %v leaves added, %v removed", p.cp.ops, len(records), len(removed))
+	p.cp.reset()
+	p.persisted = &persisted
+	// others trim logs by what we have persisted
+	if err := p.publishProgress(); err != nil {
+		logger.Warn("publish progress failed[%v]", err)
+	}
+	return nil
+}
+
+// writeBatch deletes records of removed, adds records and updates progress in one batch
+func writeBatch(s BatchNodeStorage, removed []string, records []*DBRecord, progress []*LogProgress) (err error) {
+	batch, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := batch.Rollback(); e != nil {
+				logger.Error("rollback batch failed[%v]", e)
+			}
+		}
+	}()
+	for _, gid := range removed {
+		if err := batch.Remove(gid); err != nil {
+			return err
+		}
+	}
+	for _, record := range records {
+		if err := batch.Add(record); err != nil {
+			return err
+		}
+	}
+	// it's rolled back by Commit if it fails
+	if err := batch.Commit(progress); err != nil {
+		return fmt.Errorf("commit batch failed[%v]", err)
+	}
+	return nil
+}
+
+// Checkpoint writes state replayed in memory to db, so that it's not replayed again after restart
+func (p *Participant) Checkpoint() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checkpoint()
+}
+
+// checkpointIfNeeded is called after replay
+func (p *Participant) checkpointIfNeeded() {
+	if p.cp.entries <= 0 || p.cp.ops < p.cp.entries {
+		return
+	}
+	if err := p.checkpoint(); err != nil {
+		logger.Warn("checkpoint failed[%v]", err)
+	}
+}
+
+// checkpointLoop checkpoints every interval if something has been applied
+func (p *Participant) checkpointLoop() {
+	defer close(p.cp.done)
+	ticker := time.NewTicker(p.cp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.cp.stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		if p.cp.ops > 0 {
+			if err := p.checkpoint(); err != nil {
+				logger.Warn("checkpoint in background failed[%v]", err)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *Participant) startCheckpointLoop() {
+	if p.cp.interval <= 0 {
+		return
+	}
+	p.cp.stop = make(chan struct{})
+	p.cp.done = make(chan struct{})
+	go p.checkpointLoop()
+}
+
+func (p *Participant) stopCheckpointLoop() {
+	if p.cp.stop == nil {
+		return
+	}
+	close(p.cp.stop)
+	<-p.cp.done
+	p.cp.stop = nil
+}
+
+// finalCheckpoint replays logs to the end and checkpoints, called by Close
+func (p *Participant) finalCheckpoint() error {
+	atomic.StoreInt32(&p.changed, 1)
+	if err := p.runLogTillEnd(); err != nil {
+		logger.Warn("replay before the final checkpoint failed[%v]", err)
+	}
+	if err := p.checkpoint(); err != nil {
+		return fmt.Errorf("final checkpoint failed[%v]", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkpointed reads progress of machine0 and visible values in db
+func checkpointed(t *testing.T, dbFile string) (int64, map[string]string) {
+	sqlite := SqliteAdapter{}
+	err := sqlite.Init(dbFile)
+	assert.Nil(t, err)
+	defer sqlite.Close()
+	num := int64(0)
+	processes, err := sqlite.Processes()
+	assert.Nil(t, err)
+	for _, p := range processes {
+		if p.MachineID == "machine0" {
+			num = p.Num
+		}
+	}
+	values := make(map[string]string)
+	nodes, err := sqlite.AllNodes()
+	assert.Nil(t, err)
+	for _, node := range filterVisible(nodes) {
+		values[node.Key] = node.Value
+	}
+	return num, values
+}
+
+func TestParticipantCheckpoint(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s := Participant{}
+	err := s.InitWithOptions("data", "machine0", &ParticipantOptions{CheckpointEntries: 3})
+	assert.Nil(t, err)
+	dbFile := s.me.dbFile
+	for _, key := range []string{"k1", "k2", "k3"} {
+		err = s.Save(key, "v1")
+		assert.Nil(t, err)
+	}
+	_, err = s.All()
+	assert.Nil(t, err)
+	num, values := checkpointed(t, dbFile)
+	assert.Equal(t, int64(3), num)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v1", "k3": "v1"}, values)
+
+	// fewer operations than CheckpointEntries
+	err = s.Save("k1", "v2")
+	assert.Nil(t, err)
+	err = s.Del("k2")
+	assert.Nil(t, err)
+	_, err = s.All()
+	assert.Nil(t, err)
+	num, _ = checkpointed(t, dbFile)
+	assert.Equal(t, int64(3), num)
+
+	err = s.Checkpoint()
+	assert.Nil(t, err)
+	num, values = checkpointed(t, dbFile)
+	assert.Equal(t, int64(5), num)
+	assert.Equal(t, map[string]string{"k1": "v2", "k3": "v1"}, values)
+	assert.Equal(t, int64(5), s.PeerProgress()["machine0"].Persisted["machine0"].Num)
+
+	// db is written but Close fails
+	err = s.db.workingDB.Migrator().DropTable(&LogProgress{})
+	assert.Nil(t, err)
+	err = s.Close()
+	assert.NotNil(t, err)
+}
+
+func TestParticipantCheckpointInterval(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s := Participant{}
+	err := s.InitWithOptions("data", "machine0", &ParticipantOptions{CheckpointInterval: 20 * time.Millisecond})
+	assert.Nil(t, err)
+	defer s.Close()
+	err = s.Save("k1", "v1")
+	assert.Nil(t, err)
+	_, err = s.All()
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		num, _ := checkpointed(t, s.me.dbFile)
+		return num == 1
+	}, time.Second, 20*time.Millisecond)
+}
+
+func TestParticipantRecoverToSqlite(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s := Participant{}
+	err := s.InitWithOptions("data", "machine0", &ParticipantOptions{CheckpointEntries: 100})
+	assert.Nil(t, err)
+	dbFile := s.me.dbFile
+	err = s.Save("k1", "v1")
+	assert.Nil(t, err)
+	err = s.Save("k2", "v1")
+	assert.Nil(t, err)
+	// crash before any checkpoint
+	s.w.Close()
+	s.w = nil
+	s.db.Close()
+	s.db = nil
+	s.Close()
+	num, _ := checkpointed(t, dbFile)
+	assert.Equal(t, int64(0), num)
+
+	s = Participant{}
+	err = s.InitWithOptions("data", "machine0", &ParticipantOptions{CheckpointEntries: 100})
+	assert.Nil(t, err)
+	defer s.Close()
+	num, values := checkpointed(t, dbFile)
+	assert.Equal(t, int64(2), num)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v1"}, values)
+}
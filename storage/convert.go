@@ -0,0 +1,420 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+// suffix of segments being converted in place, they replace the originals once done
+const convertingFileExt = ".converting"
+
+// suffix of the marker of an in place conversion, <wal>.convert, listing segments
+// staged. the conversion is committed once it's written, staged segments are
+// swapped in then, and it's removed after. see recoverConversion
+const convertMarkerExt = ".convert"
+
+func convertMarkerName(filename string) string {
+	return filename + convertMarkerExt
+}
+
+func encodeConvertMarker(segments []int64) []byte {
+	lines := []string{}
+	for _, segment := range segments {
+		lines = append(lines, strconv.FormatInt(segment, 10))
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func decodeConvertMarker(data []byte) ([]int64, error) {
+	segments := []int64{}
+	for _, line := range strings.Fields(string(data)) {
+		segment, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// swapSegments replaces segments by those staged, segments swapped already are skipped
+func swapSegments(filename string, segments []int64) error {
+	for _, segment := range segments {
+		src := segmentFileName(filename, segment)
+		if IsFile(src + convertingFileExt) {
+			if err := os.Rename(src+convertingFileExt, src); err != nil {
+				return err
+			}
+		}
+		// offsets in the index are those of the old format
+		if err := os.Remove(indexFileName(src)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverConversion rolls an in place conversion interrupted forward if it's committed
+// by its marker, or back by removing segments staged otherwise
+func recoverConversion(filename string) error {
+	marker := convertMarkerName(filename)
+	data, err := os.ReadFile(marker)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		segments, err := decodeConvertMarker(data)
+		if err != nil {
+			return fmt.Errorf("invalid convert marker[%v]: %v", marker, err)
+		}
+		logger.Warn("finish conversion of %v segments of wal[%v]", len(segments), filename)
+		if err := swapSegments(filename, segments); err != nil {
+			return err
+		}
+		return os.Remove(marker)
+	}
+
+	segments, err := listSegments(filename)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		staged := segmentFileName(filename, segment) + convertingFileExt
+		if !IsFile(staged) {
+			continue
+		}
+		logger.Warn("remove segment[%v] left by an interrupted conversion", staged)
+		if err := os.Remove(staged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertResult tells what has been done to a wal
+type ConvertResult struct {
+	Segments  int // segments converted
+	Skipped   int // segments already in the target format
+	Entries   int64
+	Verified  bool
+	Converted bool
+}
+
+// segmentFormat detects the format of a segment, hint is preferred if it matches
+func segmentFormat(filename string, hint LogFormat) (LogFormat, error) {
+	f, err := OpenFile(filename, true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DetectLogFormat(f, hint)
+}
+
+// convertSegment writes segment src in format to as dst, entries are copied as
+// they are, so their boundaries and signatures are kept, so is identity of the header.
+// returns the number of entries
+func convertSegment(src string, dst string, from LogFormat, to LogFormat) (int64, error) {
+	in, err := OpenFile(src, true)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	l, err := DetectLogFormat(in, from)
+	if err != nil {
+		return 0, err
+	}
+	header, err := l.ReadHeader(in)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	out, err := OpenFile(dst, false)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	// fields of the format, e.g. salt, are left to to
+	newHeader := &FileHeader{Id: header.Id, FileEnd: HeaderSize,
+		LastEntryId: header.LastEntryId, EntryNum: header.EntryNum,
+		Segment: header.Segment, StartNum: header.StartNum}
+	// entries of some formats depend on the header, e.g. the key of CryptLog
+	err = to.WriteHeader(out, newHeader)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := int64(0)
+	pos := int64(HeaderSize)
+	outPos := int64(HeaderSize)
+	for pos < header.FileEnd {
+		entry := LogEntry{}
+		readSz, err := l.ReadEntry(in, pos, &entry)
+		if err != nil {
+			return 0, fmt.Errorf("read entry at offset[%v] of [%v] failed[%v]", pos, src, err)
+		}
+		if readSz <= 0 {
+			return 0, fmt.Errorf("read size unexpected")
+		}
+		writeSz, err := to.AppendEntry(out, outPos, &entry)
+		if err != nil {
+			return 0, err
+		}
+		pos += readSz
+		outPos += writeSz
+		entries++
+	}
+
+	newHeader.FileEnd = outPos
+	err = to.WriteHeader(out, newHeader)
+	if err != nil {
+		return 0, err
+	}
+	if err := out.Flush(); err != nil {
+		return 0, err
+	}
+	return entries, nil
+}
+
+// verifySegment checks that segment b decodes to exactly what segment a does,
+// header identity, entry boundaries and operations
+func verifySegment(a string, b string, la LogFormat, lb LogFormat) error {
+	fa, err := OpenFile(a, true)
+	if err != nil {
+		return err
+	}
+	defer fa.Close()
+	fb, err := OpenFile(b, true)
+	if err != nil {
+		return err
+	}
+	defer fb.Close()
+
+	la, err = DetectLogFormat(fa, la)
+	if err != nil {
+		return err
+	}
+	lb, err = DetectLogFormat(fb, lb)
+	if err != nil {
+		return err
+	}
+	ha, err := la.ReadHeader(fa)
+	if err != nil {
+		return err
+	}
+	hb, err := lb.ReadHeader(fb)
+	if err != nil {
+		return err
+	}
+	if ha.Id != hb.Id || ha.Segment != hb.Segment || ha.StartNum != hb.StartNum ||
+		ha.EntryNum != hb.EntryNum || ha.LastEntryId != hb.LastEntryId {
+		return fmt.Errorf("header of [%v] mismatches [%v]", b, a)
+	}
+
+	posA := int64(HeaderSize)
+	posB := int64(HeaderSize)
+	for n := 0; posA < ha.FileEnd || posB < hb.FileEnd; n++ {
+		if posA >= ha.FileEnd || posB >= hb.FileEnd {
+			return fmt.Errorf("number of entries of [%v] mismatches [%v]", b, a)
+		}
+		ea := LogEntry{}
+		szA, err := la.ReadEntry(fa, posA, &ea)
+		if err != nil {
+			return err
+		}
+		eb := LogEntry{}
+		szB, err := lb.ReadEntry(fb, posB, &eb)
+		if err != nil {
+			return err
+		}
+		if szA <= 0 || szB <= 0 {
+			return fmt.Errorf("read size unexpected")
+		}
+		if !gogoproto.Equal(&ea, &eb) {
+			return fmt.Errorf("entry[%v] of [%v] mismatches [%v]", n, b, a)
+		}
+		posA += szA
+		posB += szB
+	}
+	return nil
+}
+
+// VerifyWal checks that wal b decodes to exactly what wal a does, segment by segment,
+// formats are detected, la and lb are preferred if they match
+func VerifyWal(a string, b string, la LogFormat, lb LogFormat) error {
+	segmentsA, err := listSegments(a)
+	if err != nil {
+		return err
+	}
+	segmentsB, err := listSegments(b)
+	if err != nil {
+		return err
+	}
+	if len(segmentsA) == 0 {
+		return fmt.Errorf("wal[%v] not exist", a)
+	}
+	if len(segmentsA) != len(segmentsB) {
+		return fmt.Errorf("segments of [%v] mismatch [%v]", b, a)
+	}
+	for i, segment := range segmentsA {
+		if segmentsB[i] != segment {
+			return fmt.Errorf("segments of [%v] mismatch [%v]", b, a)
+		}
+		err := verifySegment(segmentFileName(a, segment), segmentFileName(b, segment), la, lb)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertWal writes wal src in format to as dst, which must not exist,
+// segment by segment. from is preferred to read src if it matches
+func ConvertWal(src string, dst string, from LogFormat, to LogFormat, verify bool) (*ConvertResult, error) {
+	segments, err := listSegments(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("wal[%v] not exist", src)
+	}
+	if walExists(dst) {
+		return nil, fmt.Errorf("wal[%v] already exists", dst)
+	}
+
+	result := ConvertResult{}
+	for _, segment := range segments {
+		n, err := convertSegment(segmentFileName(src, segment), segmentFileName(dst, segment), from, to)
+		if err != nil {
+			return nil, err
+		}
+		result.Segments++
+		result.Entries += n
+	}
+	result.Converted = true
+	if verify {
+		if err := VerifyWal(src, dst, from, to); err != nil {
+			return nil, err
+		}
+		result.Verified = true
+	}
+	return &result, nil
+}
+
+// ConvertWalInPlace converts wal to format to. segments are written aside and
+// verified if asked, then all of them replace the originals, committed by a marker,
+// so an interrupted conversion is rolled forward or back as a whole by recoverConversion
+// when the wal is opened for writing next time. the wal must not be in use
+func ConvertWalInPlace(filename string, from LogFormat, to LogFormat, verify bool) (*ConvertResult, error) {
+	if err := recoverConversion(filename); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("wal[%v] not exist", filename)
+	}
+
+	result := ConvertResult{Verified: verify}
+	staged := []int64{}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		for _, segment := range staged {
+			os.Remove(segmentFileName(filename, segment) + convertingFileExt)
+		}
+	}()
+	for _, segment := range segments {
+		src := segmentFileName(filename, segment)
+		l, err := segmentFormat(src, from)
+		if err != nil {
+			return nil, err
+		}
+		if l.ID() == to.ID() {
+			result.Skipped++
+			continue
+		}
+		dst := src + convertingFileExt
+		staged = append(staged, segment)
+		n, err := convertSegment(src, dst, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if verify {
+			if err := verifySegment(src, dst, from, to); err != nil {
+				return nil, err
+			}
+		}
+		result.Entries += n
+	}
+	if len(staged) == 0 {
+		return &result, nil
+	}
+
+	marker := convertMarkerName(filename)
+	if err := WriteFileAtomic(marker, encodeConvertMarker(staged)); err != nil {
+		return nil, err
+	}
+	committed = true
+	if err := swapSegments(filename, staged); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(marker); err != nil {
+		return nil, err
+	}
+	result.Segments = len(staged)
+	result.Converted = true
+	return &result, nil
+}
+
+// ConvertWorkingDirectory converts wal of every participant in wd in place,
+// a participant in use is reported and left as it is. results are by participant
+func ConvertWorkingDirectory(wd string, from LogFormat, to LogFormat, verify bool) (map[string]*ConvertResult, error) {
+	all, err := discoveryAllParticipants(wd)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]*ConvertResult)
+	var firstErr error
+	for _, name := range all {
+		personalPath := getPersonalPath(wd, name)
+		walFile := getWalFilePath(personalPath)
+		if !walExists(walFile) {
+			continue
+		}
+		result, err := convertParticipant(personalPath, walFile, from, to, verify)
+		if err != nil {
+			logger.Error("convert wal of participant[%v] failed[%v]", name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("convert wal of participant[%v] failed[%v]", name, err)
+			}
+			continue
+		}
+		results[name] = result
+	}
+	return results, firstErr
+}
+
+func convertParticipant(personalPath string, walFile string, from LogFormat, to LogFormat, verify bool) (*ConvertResult, error) {
+	owner, err := acquireOwnership(personalPath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := owner.release(); err != nil {
+			logger.Error("release ownership failed[%v]", err)
+		}
+	}()
+	return ConvertWalInPlace(walFile, from, to, verify)
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestEntries(t assert.TestingT, filename string) ([][]*LogOperation, *FileHeader) {
+	wal := Wal{}
+	err := wal.Init(filename, nil, true)
+	assert.Nil(t, err)
+	defer wal.Close()
+	entries := [][]*LogOperation{}
+	it := wal.Iterator()
+	for it.Next() {
+		if it.index == 0 {
+			entries = append(entries, it.entry.Ops)
+		}
+	}
+	assert.Nil(t, it.Err())
+	return entries, wal.header
+}
+
+func TestConvertWalRoundTrip(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 12)
+	err := os.MkdirAll("data", 0777)
+	assert.Nil(t, err)
+
+	expected, header := readTestEntries(t, walFileName)
+	assert.Equal(t, 12, len(expected))
+
+	src := walFileName
+	var from LogFormat
+	formats := []LogFormat{&JsonLog{}, &FlateLog{}, newTestCryptLog(t, "secret"), &BinLog{}}
+	for i, l := range formats {
+		dst := fmt.Sprintf("data/%v.wal", i)
+		r, err := ConvertWal(src, dst, from, l, true)
+		assert.Nil(t, err)
+		assert.True(t, r.Verified)
+		assert.Equal(t, 3, r.Segments)
+		assert.Equal(t, int64(12), r.Entries)
+
+		wal := Wal{}
+		err = wal.Init(dst, l, true)
+		assert.Nil(t, err)
+		assert.Equal(t, l.ID(), wal.Format().ID())
+		wal.Close()
+		src = dst
+		from = l
+	}
+
+	entries, newHeader := readTestEntries(t, src)
+	assert.Equal(t, expected, entries)
+	assert.Equal(t, header.Id, newHeader.Id)
+	assert.Equal(t, header.EntryNum, newHeader.EntryNum)
+	assert.Nil(t, VerifyWal(walFileName, src, nil, nil))
+
+	_, err = ConvertWal(walFileName, src, nil, &JsonLog{}, false)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyWalMismatch(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 2)
+	err := os.MkdirAll("data", 0777)
+	assert.Nil(t, err)
+
+	_, err = ConvertWal(walFileName, "data/a.wal", nil, &JsonLog{}, false)
+	assert.Nil(t, err)
+
+	w := Wal{}
+	err = w.Init("data/a.wal", nil, false)
+	assert.Nil(t, err)
+	err = w.AppendRaw(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "more"})
+	assert.Nil(t, err)
+	w.Close()
+	assert.NotNil(t, VerifyWal(walFileName, "data/a.wal", nil, nil))
+}
+
+func TestConvertWorkingDirectory(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s0 := Participant{}
+	err := s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		err = s0.Save(fmt.Sprint("testKey", i), fmt.Sprint("testValue", i))
+		assert.Nil(t, err)
+	}
+	s1 := Participant{}
+	err = s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	_, err = s1.All()
+	assert.Nil(t, err)
+	s1.Close()
+
+	// in use
+	_, err = ConvertWorkingDirectory("data", nil, &FlateLog{}, true)
+	assert.NotNil(t, err)
+	s0.Close()
+
+	results, err := ConvertWorkingDirectory("data", nil, &FlateLog{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.True(t, results["machine0"].Converted)
+	assert.True(t, results["machine0"].Verified)
+	assert.False(t, IsFile(getWalFilePath(getPersonalPath("data", "machine0"))+convertingFileExt))
+
+	// offsets recorded by machine1 are of the old format
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	format, err := s0.w.Format()
+	assert.Nil(t, err)
+	assert.Equal(t, FlateLogFormat, format.ID())
+	err = s0.Save("testKey3", "testValue3")
+	assert.Nil(t, err)
+	s0.Close()
+
+	err = s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	records, err := s1.All()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(records))
+	s1.Close()
+
+	results, err = ConvertWorkingDirectory("data", nil, &FlateLog{}, false)
+	assert.Nil(t, err)
+	assert.False(t, results["machine0"].Converted)
+	assert.Equal(t, 1, results["machine0"].Skipped)
+}
+
+func TestConvertWalInPlaceRecovery(t *testing.T) {
+	t.Cleanup(delWalFile)
+	t.Cleanup(func() { os.Remove(convertMarkerName(walFileName)) })
+	prepareIndexedWal(t, 12)
+	expected, _ := readTestEntries(t, walFileName)
+	segments, err := listSegments(walFileName)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(segments))
+
+	// interrupted before the marker is written
+	src := segmentFileName(walFileName, 0)
+	_, err = convertSegment(src, src+convertingFileExt, nil, &FlateLog{})
+	assert.Nil(t, err)
+	wal := Wal{}
+	err = wal.Init(walFileName, nil, false)
+	assert.Nil(t, err)
+	wal.Close()
+	assert.False(t, IsFile(src+convertingFileExt))
+	l, err := segmentFormat(src, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, BinLogFormat, l.ID())
+
+	// interrupted after the first segment is swapped
+	for _, segment := range segments {
+		src := segmentFileName(walFileName, segment)
+		_, err = convertSegment(src, src+convertingFileExt, nil, &FlateLog{})
+		assert.Nil(t, err)
+	}
+	err = WriteFileAtomic(convertMarkerName(walFileName), encodeConvertMarker(segments))
+	assert.Nil(t, err)
+	err = swapSegments(walFileName, segments[:1])
+	assert.Nil(t, err)
+	wal = Wal{}
+	err = wal.Init(walFileName, nil, false)
+	assert.Nil(t, err)
+	wal.Close()
+	assert.False(t, IsFile(convertMarkerName(walFileName)))
+	for _, segment := range segments {
+		src := segmentFileName(walFileName, segment)
+		assert.False(t, IsFile(src+convertingFileExt))
+		l, err := segmentFormat(src, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, FlateLogFormat, l.ID())
+	}
+	entries, _ := readTestEntries(t, walFileName)
+	assert.Equal(t, expected, entries)
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
+const cryptLogVersion = 1
+
+const (
+	cryptKeySize    = 32
+	cryptSaltSize   = 16
+	cryptIterations = 1 << 16
+)
+
+// CryptLog is BinLog with every entry encrypted by AES-GCM, keys of each
+// file are derived from a shared secret and the salt in its header.
+// the header is not encrypted, but authenticated by HMAC, so that a wal can
+// be iterated by those having the secret, while the sync drive sees only ciphertext
+type CryptLog struct {
+	secret []byte
+
+	mu sync.Mutex
+	// derived keys by salt
+	keys map[string]*cryptKeys
+}
+
+// cryptKeys are derived from the secret and salt of a file, the header and
+// entries are authenticated by different keys
+type cryptKeys struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// InitWithPassphrase sets the secret shared by participants
+func (l *CryptLog) InitWithPassphrase(passphrase string) error {
+	if len(passphrase) == 0 {
+		return fmt.Errorf("empty passphrase")
+	}
+	l.secret = []byte(passphrase)
+	l.keys = make(map[string]*cryptKeys)
+	return nil
+}
+
+// InitWithKeyFile uses content of filename as the secret,
+// trailing new line is ignored
+func (l *CryptLog) InitWithKeyFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) < cryptKeySize {
+		return fmt.Errorf("key file[%v] too short, at least %v bytes", filename, cryptKeySize)
+	}
+	l.secret = data
+	l.keys = make(map[string]*cryptKeys)
+	return nil
+}
+
+func (l *CryptLog) ID() LogFormatID {
+	return CryptLogFormat
+}
+
+func (l *CryptLog) Name() string {
+	return "aes"
+}
+
+func (l *CryptLog) key(salt []byte) (*cryptKeys, error) {
+	if len(l.secret) == 0 {
+		return nil, fmt.Errorf("secret of encrypted wal not set")
+	}
+	if len(salt) != cryptSaltSize {
+		return nil, fmt.Errorf("invalid salt")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if keys, ok := l.keys[string(salt)]; ok {
+		return keys, nil
+	}
+	master := pbkdf2(l.secret, salt, cryptIterations, cryptKeySize, sha256.New)
+	block, err := aes.NewCipher(hkdfExpand(master, []byte("datacross wal entry"), cryptKeySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	keys := &cryptKeys{mac: hkdfExpand(master, []byte("datacross wal header"), cryptKeySize, sha256.New), aead: aead}
+	l.keys[string(salt)] = keys
+	return keys, nil
+}
+
+// headerMac is calculated on the header with mac and checksum cleared
+func headerMac(key []byte, header *FileHeader) ([]byte, error) {
+	h := gogoproto.Clone(header).(*FileHeader)
+	h.Mac = nil
+	h.Checksum = 0
+	data, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// 写失败将破坏文件数据
+// salt is generated and set to header if it has none
+func (l *CryptLog) WriteHeader(f File, header *FileHeader) error {
+	if len(header.Salt) == 0 {
+		salt := make([]byte, cryptSaltSize)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return err
+		}
+		header.Salt = salt
+	}
+	keys, err := l.key(header.Salt)
+	if err != nil {
+		return err
+	}
+
+	h := gogoproto.Clone(header).(*FileHeader)
+	h.Magic = WalMagic
+	h.Format = int32(l.ID())
+	h.Version = cryptLogVersion
+	h.Mac, err = headerMac(keys.mac, h)
+	if err != nil {
+		return err
+	}
+	return writeBinHeader(f, h, l.ID(), cryptLogVersion)
+}
+
+// readHeader reads header without verifying its mac
+func (l *CryptLog) readHeader(f File) (*FileHeader, error) {
+	header, err := readBinHeader(f, l.ID(), cryptLogVersion)
+	if err != nil {
+		return nil, err
+	}
+	// headers without magic are never encrypted
+	if header.Format != int32(l.ID()) {
+		return nil, fmt.Errorf("not an encrypted wal file")
+	}
+	return header, nil
+}
+
+// IsValidFile checks if f has a header written by CryptLog, secret is not needed
+func (l *CryptLog) IsValidFile(f File) (bool, error) {
+	has, err := hasHeader(f)
+	if err != nil || !has {
+		return false, err
+	}
+	_, err = l.readHeader(f)
+	return err == nil, nil
+}
+
+func (l *CryptLog) ReadHeader(f File) (*FileHeader, error) {
+	header, err := l.readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := l.key(header.Salt)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := headerMac(keys.mac, header)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, header.Mac) {
+		return nil, fmt.Errorf("header authentication failed, wrong secret?")
+	}
+	return header, nil
+}
+
+// aead of f is cached by salt of its header
+func (l *CryptLog) aead(f File) (cipher.AEAD, error) {
+	header, err := l.readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := l.key(header.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return keys.aead, nil
+}
+
+// entries are bound to their position, so they can't be moved around
+func entryAdditionalData(pos int64) []byte {
+	data := [8]byte{}
+	binary.LittleEndian.PutUint64(data[:], uint64(pos))
+	return data[:]
+}
+
+// 写失败将破坏文件数据
+// entry is stored as nonce + ciphertext
+func (l *CryptLog) AppendEntry(f File, pos int64, entry *LogEntry) (int64, error) {
+	sz := entry.Size()
+	if sz == 0 {
+		return 0, nil
+	}
+	aead, err := l.aead(f)
+	if err != nil {
+		return 0, err
+	}
+	if pos == -1 {
+		pos, err = f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+	}
+	data, err := entry.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return 0, err
+	}
+	sealed := aead.Seal(nonce, nonce, data, entryAdditionalData(pos))
+	return appendBinFrame(f, pos, sealed)
+}
+
+func (l *CryptLog) ReadEntry(f File, pos int64, entry *LogEntry) (_ int64, err error) {
+	defer recoverFault(&err, debug.SetPanicOnFault(true))
+	aead, err := l.aead(f)
+	if err != nil {
+		return 0, err
+	}
+	sealed, n, err := readBinFrame(f, pos)
+	if err != nil {
+		return 0, err
+	}
+	if sealed == nil {
+		entry.Reset()
+		return n, nil
+	}
+	if len(sealed) < aead.NonceSize() {
+		return 0, fmt.Errorf("invalid encrypted entry pos[%v]", pos)
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, entryAdditionalData(pos))
+	if err != nil {
+		return 0, fmt.Errorf("decrypt entry failed pos[%v] err[%v]", pos, err)
+	}
+	err = entry.Unmarshal(data)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// pbkdf2 as in RFC 8018
+func pbkdf2(password, salt []byte, iter, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// hkdfExpand as in RFC 5869, prk is already uniformly random so extracting is skipped
+func hkdfExpand(prk, info []byte, keyLen int, h func() hash.Hash) []byte {
+	mac := hmac.New(h, prk)
+	okm := make([]byte, 0, keyLen+mac.Size())
+	t := []byte{}
+	for counter := byte(1); len(okm) < keyLen; counter++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		t = mac.Sum(t[:0])
+		okm = append(okm, t...)
+	}
+	return okm[:keyLen]
+}
+
+func init() {
+	RegisterLogFormat(&CryptLog{})
+}
+
+func _() {
+	var _ LogFormat = &CryptLog{}
+}
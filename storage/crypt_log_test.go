@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCryptLog(t assert.TestingT, passphrase string) *CryptLog {
+	l := CryptLog{}
+	err := l.InitWithPassphrase(passphrase)
+	assert.Nil(t, err)
+	return &l
+}
+
+func TestPbkdf2(t *testing.T) {
+	dk := pbkdf2([]byte("password"), []byte("salt"), 1, 32, sha256.New)
+	assert.Equal(t, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b", hex.EncodeToString(dk))
+	dk = pbkdf2([]byte("password"), []byte("salt"), 2, 32, sha256.New)
+	assert.Equal(t, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43", hex.EncodeToString(dk))
+}
+
+func TestHkdfExpand(t *testing.T) {
+	// test case 1 of RFC 5869
+	prk, _ := hex.DecodeString("077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	okm := hkdfExpand(prk, info, 42, sha256.New)
+	assert.Equal(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865", hex.EncodeToString(okm))
+}
+
+func TestCryptLogKeys(t *testing.T) {
+	l := newTestCryptLog(t, "secret")
+	salt := bytes.Repeat([]byte{1}, cryptSaltSize)
+	keys, err := l.key(salt)
+	assert.Nil(t, err)
+	cached, err := l.key(salt)
+	assert.Nil(t, err)
+	assert.True(t, keys == cached)
+	assert.True(t, keys.aead == cached.aead)
+	// the header mac key is not the key of entries
+	master := pbkdf2([]byte("secret"), salt, cryptIterations, cryptKeySize, sha256.New)
+	assert.NotEqual(t, master, keys.mac)
+}
+
+func TestCryptLogHeader(t *testing.T) {
+	t.Cleanup(delFile)
+	testHeader(t, newTestCryptLog(t, "secret"))
+}
+
+func TestCryptLogLogEntry(t *testing.T) {
+	t.Cleanup(delFile)
+	testLogEntry(t, newTestCryptLog(t, "secret"))
+}
+
+func TestWalAppendWithCryptLog(t *testing.T) {
+	getWalFile()
+	t.Cleanup(delWalFile)
+	testWalAppend(t, newTestCryptLog(t, "secret"))
+}
+
+func TestCryptLogCiphertext(t *testing.T) {
+	defer delWalFile()
+	wal := Wal{}
+	err := wal.Init(getWalFile(), newTestCryptLog(t, "secret"), false)
+	assert.Nil(t, err)
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "plainKey", Value: "plainValue"})
+	assert.Nil(t, err)
+	wal.Close()
+
+	data, err := os.ReadFile(walFileName)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, []byte("plainKey")))
+	assert.False(t, bytes.Contains(data, []byte("plainValue")))
+
+	// detected without the secret, but can't be read
+	wal = Wal{}
+	err = wal.Init(walFileName, nil, true)
+	assert.NotNil(t, err)
+	wal = Wal{}
+	err = wal.Init(walFileName, newTestCryptLog(t, "wrong"), true)
+	assert.NotNil(t, err)
+
+	wal = Wal{}
+	err = wal.Init(walFileName, newTestCryptLog(t, "secret"), true)
+	assert.Nil(t, err)
+	defer wal.Close()
+	i := wal.Iterator()
+	assert.True(t, i.Next())
+	assert.Equal(t, "plainValue", i.LogOp().Value)
+}
+
+func TestCryptLogTampered(t *testing.T) {
+	t.Cleanup(delFile)
+	l := newTestCryptLog(t, "secret")
+	f := getFile(t)
+	defer f.Close()
+	header := FileHeader{Id: "test", FileEnd: HeaderSize}
+	err := l.WriteHeader(f, &header)
+	assert.Nil(t, err)
+	assert.Equal(t, cryptSaltSize, len(header.Salt))
+
+	entry := LogEntry{Ops: []*LogOperation{{Op: int32(Op_Modify), Key: "key", Value: "value"}}}
+	n, err := l.AppendEntry(f, -1, &entry)
+	assert.Nil(t, err)
+	// entries can't be moved around
+	_, err = l.AppendEntry(f, -1, &entry)
+	assert.Nil(t, err)
+	data := make([]byte, n)
+	_, err = f.Seek(HeaderSize, 0)
+	assert.Nil(t, err)
+	_, err = f.Read(data)
+	assert.Nil(t, err)
+	_, err = f.Seek(HeaderSize+n, 0)
+	assert.Nil(t, err)
+	_, err = f.Write(data)
+	assert.Nil(t, err)
+	_, err = l.ReadEntry(f, HeaderSize, &LogEntry{})
+	assert.Nil(t, err)
+	_, err = l.ReadEntry(f, HeaderSize+n, &LogEntry{})
+	assert.NotNil(t, err)
+
+	// header is authenticated
+	header.FileEnd = HeaderSize + 2*n
+	h, err := sealHeader(&header, l.ID(), cryptLogVersion)
+	assert.Nil(t, err)
+	h.Mac = make([]byte, len(h.Mac))
+	err = writeBinHeader(f, h, l.ID(), cryptLogVersion)
+	assert.Nil(t, err)
+	valid, err := l.IsValidFile(f)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	_, err = l.ReadHeader(f)
+	assert.NotNil(t, err)
+}
+
+func TestParticipantEncrypted(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s0 := Participant{}
+	err := s0.InitWithOptions("data", "machine0", &ParticipantOptions{LogFormat: newTestCryptLog(t, "secret")})
+	assert.Nil(t, err)
+	err = s0.Save("testKey", "testValue")
+	assert.Nil(t, err)
+	s0.Close()
+
+	s1 := Participant{}
+	err = s1.InitWithOptions("data", "machine1", &ParticipantOptions{LogFormat: newTestCryptLog(t, "secret")})
+	assert.Nil(t, err)
+	defer s1.Close()
+	v, err := s1.Load("testKey")
+	assert.Nil(t, err)
+	assert.Equal(t, "testValue", v.Main().value)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"sort"
+)
+
+// KeyChange is the main value of a key before and after, nil if it doesn't exist
+type KeyChange struct {
+	Key    string
+	Before *Value
+	After  *Value
+}
+
+// DryRunReport tells what replaying pending operations would do
+type DryRunReport struct {
+	// keys whose main value would change, created keys included, ordered by key
+	Changed []*KeyChange
+	// keys which would be in conflict and are not now
+	Conflicts []string
+	// keys which would be deleted
+	Deleted []string
+	// operations which would be applied, by machine
+	Applied map[string]int64
+	// logs which would be blocked or stopped by errors, by machine
+	Blocked map[string]*BlockedReplay
+	Failed  map[string]error
+}
+
+// DryRun replays pending operations into an overlay of the current storage and
+// reports what would change, nothing is changed, neither in memory nor in db
+func (p *Participant) DryRun() (*DryRunReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	m := LogProgressMgr{}
+	m.Init()
+	for name, progress := range p.m.m {
+		copied := *progress
+		m.Set(name, &copied)
+	}
+	// wal cached by network are left as they are
+	wals := []*Wal{}
+	defer func() {
+		for _, w := range wals {
+			if err := w.Close(); err != nil {
+				logger.Error("close wal file[%v] failed[%v]", w.filename, err)
+			}
+		}
+	}()
+	inputs, failed, err := makeRunLogInputs(p.network, &m, func(participant *ParticipantInfo) (*Wal, error) {
+		w := Wal{}
+		if err := w.InitMmap(participant.walFile, p.l); err != nil {
+			return nil, err
+		}
+		wals = append(wals, &w)
+		return &w, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	overlay := OverlayStorage{}
+	overlay.Init(p.ns)
+	runner := LogRunner{}
+	err = runner.Init(p.me.name, &overlay)
+	if err != nil {
+		return nil, err
+	}
+	// tells how long they have been blocked
+	runner.blocked = p.runner.blocked
+	touched := make(map[string]bool)
+	runner.SetObserver(func(op *LogOperation, replaced bool) {
+		touched[op.Key] = true
+	})
+	results, err := runner.Run(inputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := DryRunReport{Applied: make(map[string]int64), Blocked: results.Blocked(), Failed: results.Failed()}
+	for name, err := range failed {
+		report.Failed[name] = err
+	}
+	for _, input := range inputs {
+		if n := results.Process(input.machineID).Num - input.progress.Num; n > 0 {
+			report.Applied[input.machineID] = n
+		}
+	}
+
+	keys := make([]string, 0, len(touched))
+	for key := range touched {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		before, err := visibleValue(p.ns, key, p.me.name)
+		if err != nil {
+			return nil, err
+		}
+		after, err := visibleValue(&overlay, key, p.me.name)
+		if err != nil {
+			return nil, err
+		}
+		if before != nil && after == nil {
+			report.Deleted = append(report.Deleted, key)
+			continue
+		}
+		if after == nil {
+			continue
+		}
+		if before == nil || before.Main().value != after.Main().value {
+			report.Changed = append(report.Changed, &KeyChange{Key: key, Before: before, After: after})
+		}
+		if len(after.Versions()) > 1 && (before == nil || len(before.Versions()) <= 1) {
+			report.Conflicts = append(report.Conflicts, key)
+		}
+	}
+	return &report, nil
+}
+
+// visibleValue returns nil if key doesn't exist
+func visibleValue(ns ReadOnlyNodeStorage, key string, me string) (*Value, error) {
+	leaves, err := ns.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(filterVisible(leaves)) == 0 {
+		return nil, nil
+	}
+	return loadValue(ns, key, me)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParticipantDryRun(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s1 := Participant{}
+	err := s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	defer s1.Close()
+	s0 := Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s0.Close()
+
+	for _, kv := range [][2]string{{"k1", "a"}, {"k2", "b"}, {"k3", "c"}} {
+		err = s1.Save(kv[0], kv[1])
+		assert.Nil(t, err)
+	}
+	_, err = s0.All()
+	assert.Nil(t, err)
+	// concurrent modifications of k3
+	err = s0.Save("k3", "c2")
+	assert.Nil(t, err)
+
+	err = s1.Save("k1", "a2")
+	assert.Nil(t, err)
+	err = s1.Del("k2")
+	assert.Nil(t, err)
+	err = s1.Save("k4", "d")
+	assert.Nil(t, err)
+	err = s1.Save("k3", "c3")
+	assert.Nil(t, err)
+
+	cached := map[string]*Wal{}
+	for name, w := range s0.network.wals {
+		cached[name] = w
+	}
+	report, err := s0.DryRun()
+	assert.Nil(t, err)
+	// wal of network are not opened, refreshed or closed
+	assert.Equal(t, cached, s0.network.wals)
+	assert.Equal(t, int64(3), s0.network.wals["machine1"].header.EntryNum)
+	changed := map[string]*KeyChange{}
+	for _, c := range report.Changed {
+		changed[c.Key] = c
+	}
+	assert.Equal(t, "a", changed["k1"].Before.Main().value)
+	assert.Equal(t, "a2", changed["k1"].After.Main().value)
+	assert.Nil(t, changed["k4"].Before)
+	assert.Equal(t, "d", changed["k4"].After.Main().value)
+	assert.Equal(t, []string{"k2"}, report.Deleted)
+	assert.Equal(t, []string{"k3"}, report.Conflicts)
+	assert.Equal(t, map[string]int64{"machine0": 1, "machine1": 4}, report.Applied)
+	assert.Equal(t, 0, len(report.Blocked))
+	assert.Equal(t, 0, len(report.Failed))
+
+	// nothing is changed
+	v, err := visibleValue(s0.ns, "k1", "machine0")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", v.Main().value)
+	v, err = visibleValue(s0.ns, "k4", "machine0")
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	assert.Equal(t, int64(3), s0.m.Get("machine1").Num)
+
+	v, err = s0.Load("k1")
+	assert.Nil(t, err)
+	assert.Equal(t, "a2", v.Main().value)
+	has, err := s0.Has("k2")
+	assert.Nil(t, err)
+	assert.False(t, has)
+}
+
+func TestOverlayStorage(t *testing.T) {
+	base := NodeStorageImpl{}
+	base.Init()
+	err := base.Add(&DBRecord{Key: "testKey", Value: "v1", CurrentLogGid: "gid1"})
+	assert.Nil(t, err)
+
+	o := OverlayStorage{}
+	o.Init(&base)
+	assertValue := func(gid string, value string) {
+		record, err := o.GetByGid(gid)
+		assert.Nil(t, err)
+		assert.Equal(t, value, record.Value)
+	}
+	err = o.Replace("gid1", &DBRecord{Key: "testKey", Value: "v2", CurrentLogGid: "gid2"})
+	assert.Nil(t, err)
+	assertValue("gid2", "v2")
+	_, err = o.GetByGid("gid1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = o.Replace("gid2", &DBRecord{Key: "testKey", Value: "v3", CurrentLogGid: "gid3"})
+	assert.Nil(t, err)
+	assertValue("gid3", "v3")
+	err = o.Add(&DBRecord{Key: "testKey", Value: "v3", CurrentLogGid: "gid3"})
+	assert.ErrorIs(t, err, ErrExists)
+
+	records, err := o.GetByKey("testKey")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(filterVisible(records)))
+	all, err := o.AllNodes()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(all))
+
+	// the base is untouched
+	record, err := base.GetByGid("gid1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", record.Value)
+}
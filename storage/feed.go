@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"strings"
+	"sync"
+)
+
+// ChangeEvent is an operation applied, local or remote
+type ChangeEvent struct {
+	Key       string
+	Op        Op
+	Gid       string
+	Num       int64
+	MachineID string // who made it
+	Local     bool   // made by us
+	Timestamp HLCTimestamp
+	// it's added beside other versions of the key, so the key is in conflict
+	NewBranch bool
+	// the value after it's applied, nil if the key is deleted
+	Value *Value
+}
+
+// Subscription receives change events of keys with its prefix from C in the order
+// they are applied. events are queued, so a slow receiver never blocks replay
+type Subscription struct {
+	prefix string
+	c      chan *ChangeEvent
+	p      *Participant
+
+	mu     sync.Mutex
+	queue  []*ChangeEvent
+	signal chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+	once   sync.Once
+}
+
+func (s *Subscription) init(p *Participant, prefix string) {
+	s.prefix = prefix
+	s.c = make(chan *ChangeEvent)
+	s.p = p
+	s.signal = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	s.exited = make(chan struct{})
+	go s.loop()
+}
+
+// C is closed when the subscription or the participant is closed
+func (s *Subscription) C() <-chan *ChangeEvent {
+	return s.c
+}
+
+// Close stops the subscription, events not received are dropped
+func (s *Subscription) Close() {
+	s.p.mu.Lock()
+	delete(s.p.subscriptions, s)
+	s.p.mu.Unlock()
+	s.stop()
+}
+
+func (s *Subscription) stop() {
+	s.once.Do(func() {
+		close(s.done)
+		<-s.exited
+		close(s.c)
+	})
+}
+
+func (s *Subscription) push(e *ChangeEvent) {
+	s.mu.Lock()
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) loop() {
+	defer close(s.exited)
+	for {
+		select {
+		case <-s.signal:
+		case <-s.done:
+			return
+		}
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			e := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			select {
+			case s.c <- e:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Watch subscribes to operations applied to keys with prefix, empty prefix means all keys.
+// operations of others are applied when their logs are replayed, which is done by calls
+// or in background if ParticipantOptions.Watch is set, so are our own writes
+func (p *Participant) Watch(prefix string) *Subscription {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := Subscription{}
+	s.init(p, prefix)
+	if p.subscriptions == nil {
+		p.subscriptions = make(map[*Subscription]struct{})
+	}
+	p.subscriptions[&s] = struct{}{}
+	return &s
+}
+
+// applied is called by the runner with p.mu held
+func (p *Participant) applied(op *LogOperation, replaced bool) {
+	p.cp.track(op, replaced)
+	var e *ChangeEvent
+	for s := range p.subscriptions {
+		if !strings.HasPrefix(op.Key, s.prefix) {
+			continue
+		}
+		if e == nil {
+			e = p.makeChangeEvent(op, replaced)
+		}
+		s.push(e)
+	}
+}
+
+func (p *Participant) makeChangeEvent(op *LogOperation, replaced bool) *ChangeEvent {
+	e := ChangeEvent{
+		Key:       op.Key,
+		Op:        Op(op.Op),
+		Gid:       op.Gid,
+		Num:       op.Num,
+		MachineID: op.MachineId,
+		Local:     op.MachineId == p.me.name,
+		Timestamp: HLCTimestamp(op.Hlc),
+	}
+	leaves, err := p.ns.GetByKey(op.Key)
+	if err != nil {
+		logger.Warn("get leaves of key[%v] failed[%v]", op.Key, err)
+		return &e
+	}
+	leaves = filterVisible(leaves)
+	e.NewBranch = !replaced && len(leaves) > 1 && Op(op.Op) == Op_Modify
+	if len(leaves) == 0 {
+		return &e
+	}
+	v := Value{}
+	if err := v.from(leaves, p.me.name); err != nil {
+		logger.Warn("make value of key[%v] failed[%v]", op.Key, err)
+		return &e
+	}
+	e.Value = &v
+	return &e
+}
+
+// closeSubscriptions is called with p.mu held
+func (p *Participant) closeSubscriptions() {
+	for s := range p.subscriptions {
+		s.stop()
+	}
+	p.subscriptions = nil
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveEvents(t *testing.T, s *Subscription, n int) []*ChangeEvent {
+	events := []*ChangeEvent{}
+	for len(events) < n {
+		select {
+		case e := <-s.C():
+			events = append(events, e)
+		case <-time.After(time.Second):
+			assert.Fail(t, "change event is not received")
+			return events
+		}
+	}
+	return events
+}
+
+func TestParticipantChangeFeed(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s1 := Participant{}
+	err := s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	defer s1.Close()
+	s0 := Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s0.Close()
+
+	sub := s0.Watch("test")
+	err = s1.Save("otherKey", "v0")
+	assert.Nil(t, err)
+	err = s1.Save("testKey", "v1")
+	assert.Nil(t, err)
+	_, err = s0.All()
+	assert.Nil(t, err)
+
+	events := receiveEvents(t, sub, 1)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "testKey", events[0].Key)
+	assert.Equal(t, Op_Modify, events[0].Op)
+	assert.Equal(t, "machine1", events[0].MachineID)
+	assert.False(t, events[0].Local)
+	assert.False(t, events[0].NewBranch)
+	assert.Equal(t, "v1", events[0].Value.Main().value)
+	assert.False(t, events[0].Timestamp.IsZero())
+
+	// both modify v1, one of them makes a new branch
+	err = s0.Save("testKey", "v2")
+	assert.Nil(t, err)
+	err = s1.Save("testKey", "v3")
+	assert.Nil(t, err)
+	_, err = s0.All()
+	assert.Nil(t, err)
+	events = receiveEvents(t, sub, 2)
+	assert.Equal(t, 2, len(events))
+	local, branches := 0, 0
+	for _, e := range events {
+		if e.Local {
+			local++
+		}
+		if e.NewBranch {
+			branches++
+			assert.Equal(t, 2, len(e.Value.Versions()))
+		}
+	}
+	assert.Equal(t, 1, local)
+	assert.Equal(t, 1, branches)
+
+	sub.Close()
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"fmt"
 	"io"
+	"runtime/debug"
 )
 
 // 不要依赖文件内部维护的position
@@ -9,5 +11,29 @@ type File interface {
 	io.ReadWriteCloser
 	io.Seeker
 	Flush() error
+	Truncate(size int64) error
 	Path() string
 }
+
+// implemented by files which can expose their content without copying
+type sliceReader interface {
+	// slice returns n bytes at offset, valid until the next call to the file,
+	// readers of it defer recoverFault in case the file shrinks meanwhile
+	slice(offset int64, n int) ([]byte, error)
+}
+
+// recoverFault turns a fault of reading a mapped file into *err, e.g. SIGBUS raised when
+// the file is truncated in place by a sync client while it's mapped. it's deferred with
+// the setting returned by debug.SetPanicOnFault(true)
+func recoverFault(err *error, panicOnFault bool) {
+	debug.SetPanicOnFault(panicOnFault)
+	r := recover()
+	if r == nil {
+		return
+	}
+	if fault, ok := r.(interface{ Addr() uintptr }); ok {
+		*err = fmt.Errorf("read mapped file failed[%v]", fault)
+		return
+	}
+	panic(r)
+}
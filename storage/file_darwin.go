@@ -37,6 +37,10 @@ func (f *darwinFlie) Flush() error {
 	return syscall.Fsync(f.fd)
 }
 
+func (f *darwinFlie) Truncate(size int64) error {
+	return syscall.Ftruncate(f.fd, size)
+}
+
 func (f *darwinFlie) Path() string {
 	return f.filename
 }
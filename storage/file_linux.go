@@ -37,6 +37,10 @@ func (f *linuxFile) Flush() error {
 	return syscall.Fsync(f.fd)
 }
 
+func (f *linuxFile) Truncate(size int64) error {
+	return syscall.Ftruncate(f.fd, size)
+}
+
 func (f *linuxFile) Path() string {
 	return f.filename
 }
@@ -59,6 +59,14 @@ func (f *winFile) Flush() error {
 	return syscall.FlushFileBuffers(f.handle)
 }
 
+func (f *winFile) Truncate(size int64) error {
+	_, err := f.Seek(size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return syscall.SetEndOfFile(f.handle)
+}
+
 func (f *winFile) Path() string {
 	return f.filename
 }
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+const flateLogVersion = 1
+
+// FlateLog is BinLog with every entry compressed by deflate,
+// crc is calculated on the compressed data
+type FlateLog struct {
+	// compression level of flate, 0 means flate.DefaultCompression
+	Level int
+}
+
+func (l *FlateLog) ID() LogFormatID {
+	return FlateLogFormat
+}
+
+func (l *FlateLog) Name() string {
+	return "flate"
+}
+
+// 写失败将破坏文件数据
+func (l *FlateLog) WriteHeader(f File, header *FileHeader) error {
+	return writeBinHeader(f, header, l.ID(), flateLogVersion)
+}
+
+// IsValidFile checks if f has a header written by FlateLog
+func (l *FlateLog) IsValidFile(f File) (bool, error) {
+	has, err := hasHeader(f)
+	if err != nil || !has {
+		return false, err
+	}
+	_, err = l.ReadHeader(f)
+	return err == nil, nil
+}
+
+func (l *FlateLog) ReadHeader(f File) (*FileHeader, error) {
+	return readBinHeader(f, l.ID(), flateLogVersion)
+}
+
+func (l *FlateLog) level() int {
+	if l.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return l.Level
+}
+
+// 写失败将破坏文件数据
+func (l *FlateLog) AppendEntry(f File, pos int64, entry *LogEntry) (int64, error) {
+	sz := entry.Size()
+	if sz == 0 {
+		return 0, nil
+	}
+	data, err := entry.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	buf := bytes.Buffer{}
+	w, err := flate.NewWriter(&buf, l.level())
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return 0, err
+	}
+	err = w.Close()
+	if err != nil {
+		return 0, err
+	}
+	return appendBinFrame(f, pos, buf.Bytes())
+}
+
+func (l *FlateLog) ReadEntry(f File, pos int64, entry *LogEntry) (_ int64, err error) {
+	defer recoverFault(&err, debug.SetPanicOnFault(true))
+	compressed, n, err := readBinFrame(f, pos)
+	if err != nil {
+		return 0, err
+	}
+	if compressed == nil {
+		entry.Reset()
+		return n, nil
+	}
+
+	r := flate.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("decompress entry failed pos[%v] err[%v]", pos, err)
+	}
+	err = entry.Unmarshal(data)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func init() {
+	RegisterLogFormat(&FlateLog{})
+}
+
+func _() {
+	var _ LogFormat = &FlateLog{}
+}
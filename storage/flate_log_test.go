@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlateLogHeader(t *testing.T) {
+	t.Cleanup(delFile)
+	testHeader(t, &FlateLog{})
+}
+
+func TestFlateLogLogEntry(t *testing.T) {
+	t.Cleanup(delFile)
+	testLogEntry(t, &FlateLog{})
+}
+
+func TestWalAppendWithFlateLog(t *testing.T) {
+	getWalFile()
+	t.Cleanup(delWalFile)
+	testWalAppend(t, &FlateLog{})
+}
+
+func TestFlateLogCompress(t *testing.T) {
+	t.Cleanup(delFile)
+	value := strings.Repeat("value", 1000)
+	entry := LogEntry{Ops: []*LogOperation{{Op: int32(Op_Modify), Key: "key", Value: value, PrevValue: value}}}
+
+	sizes := []int64{}
+	for _, l := range []LogFormat{&BinLog{}, &FlateLog{}} {
+		f := getFile(t)
+		err := l.WriteHeader(f, &FileHeader{FileEnd: HeaderSize})
+		assert.Nil(t, err)
+		n, err := l.AppendEntry(f, -1, &entry)
+		assert.Nil(t, err)
+		sizes = append(sizes, n)
+
+		read := LogEntry{}
+		_, err = l.ReadEntry(f, HeaderSize, &read)
+		assert.Nil(t, err)
+		assert.Equal(t, value, read.Ops[0].PrevValue)
+		f.Close()
+	}
+	assert.Less(t, sizes[1]*10, sizes[0])
+}
+
+func TestFlateLogCorrupted(t *testing.T) {
+	t.Cleanup(delFile)
+	l := &FlateLog{}
+	f := getFile(t)
+	defer f.Close()
+	err := l.WriteHeader(f, &FileHeader{FileEnd: HeaderSize})
+	assert.Nil(t, err)
+	_, err = l.AppendEntry(f, -1, &LogEntry{Ops: []*LogOperation{{Op: int32(Op_Modify), Key: "key", Value: "value"}}})
+	assert.Nil(t, err)
+
+	_, err = f.Seek(HeaderSize+5, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0xff})
+	assert.Nil(t, err)
+	_, err = l.ReadEntry(f, HeaderSize, &LogEntry{})
+	assert.NotNil(t, err)
+}
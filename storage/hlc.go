@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const hlcLogicalBits = 16
+const hlcLogicalMask = 1<<hlcLogicalBits - 1
+
+// HLCTimestamp is a hybrid logical clock timestamp, wall time in milliseconds in
+// the high bits and a logical counter in the low 16 bits, so it's ordered as an integer.
+// 0 means unknown, e.g. operations logged before timestamps were introduced
+type HLCTimestamp int64
+
+func NewHLCTimestamp(wall time.Time, logical uint16) HLCTimestamp {
+	return HLCTimestamp(wall.UnixMilli()<<hlcLogicalBits | int64(logical))
+}
+
+// Time is the wall time part
+func (t HLCTimestamp) Time() time.Time {
+	return time.UnixMilli(int64(t) >> hlcLogicalBits)
+}
+
+func (t HLCTimestamp) Logical() uint16 {
+	return uint16(t & hlcLogicalMask)
+}
+
+func (t HLCTimestamp) IsZero() bool {
+	return t == 0
+}
+
+func (t HLCTimestamp) String() string {
+	if t.IsZero() {
+		return "-"
+	}
+	return fmt.Sprintf("%v+%v", t.Time().Format("2006-01-02T15:04:05.000Z07:00"), t.Logical())
+}
+
+// HLC is a hybrid logical clock, timestamps it makes are greater than all timestamps
+// made or observed before, and close to the wall clock
+type HLC struct {
+	mu   sync.Mutex
+	last HLCTimestamp
+	now  func() time.Time // for testing, time.Now if nil
+}
+
+// Now makes a timestamp for a local operation
+func (c *HLC) Now() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	wall := NewHLCTimestamp(now(), 0)
+	if wall > c.last {
+		c.last = wall
+	} else {
+		// the wall clock is behind, or within the same millisecond
+		c.last++
+	}
+	return c.last
+}
+
+// Update observes a timestamp made by others, or made before a restart
+func (c *HLC) Update(t HLCTimestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t > c.last {
+		c.last = t
+	}
+}
+
+// Last returns the greatest timestamp made or observed
+func (c *HLC) Last() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHLCTimestamp(t *testing.T) {
+	wall := time.UnixMilli(1700000000123)
+	ts := NewHLCTimestamp(wall, 7)
+	assert.True(t, ts.Time().Equal(wall))
+	assert.Equal(t, uint16(7), ts.Logical())
+	assert.True(t, NewHLCTimestamp(wall, 8) > ts)
+	assert.True(t, NewHLCTimestamp(wall.Add(time.Millisecond), 0) > ts)
+	assert.True(t, HLCTimestamp(0).IsZero())
+}
+
+func TestHLCMonotonic(t *testing.T) {
+	wall := time.UnixMilli(1700000000000)
+	c := HLC{now: func() time.Time { return wall }}
+
+	t1 := c.Now()
+	assert.Equal(t, NewHLCTimestamp(wall, 0), t1)
+	t2 := c.Now()
+	assert.Equal(t, NewHLCTimestamp(wall, 1), t2)
+
+	// the wall clock goes backwards
+	wall = wall.Add(-time.Second)
+	t3 := c.Now()
+	assert.True(t, t3 > t2)
+
+	// a peer is ahead
+	remote := NewHLCTimestamp(wall.Add(time.Minute), 3)
+	c.Update(remote)
+	assert.Equal(t, remote, c.Last())
+	assert.True(t, c.Now() > remote)
+
+	wall = wall.Add(time.Hour)
+	assert.Equal(t, NewHLCTimestamp(wall, 0), c.Now())
+}
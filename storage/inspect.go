@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ScannedEntry is an entry of a wal and where it's stored
+type ScannedEntry struct {
+	Segment int64
+	Offset  int64
+	Size    int64
+	Entry   *LogEntry
+}
+
+// ScanWal calls fn with every entry of wal in order, empty ones included,
+// format is detected, l is preferred if it matches. segments are scanned one by one,
+// a damaged header or entry is returned as a problem, and the scan goes on with the
+// next segment. error is returned if the scan can't be done or fn fails
+func ScanWal(filename string, l LogFormat, fn func(e *ScannedEntry) error) ([]*WalProblem, error) {
+	segments, err := listSegments(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("wal[%v] not exist", filename)
+	}
+
+	problems := []*WalProblem{}
+	for _, segment := range segments {
+		problem, err := scanSegment(segmentFileName(filename, segment), l, segment, fn)
+		if err != nil {
+			return problems, err
+		}
+		if problem != nil {
+			problems = append(problems, problem)
+		}
+	}
+	return problems, nil
+}
+
+// scanSegment calls fn with entries of a segment till file end in its header,
+// the first bad entry stops it and is returned as the problem
+func scanSegment(filename string, hint LogFormat, segment int64, fn func(e *ScannedEntry) error) (*WalProblem, error) {
+	f, err := OpenFile(filename, true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := DetectLogFormat(f, hint)
+	if err != nil {
+		return &WalProblem{segment, 0, fmt.Sprintf("unknown format: %v", err)}, nil
+	}
+	header, err := l.ReadHeader(f)
+	if err != nil {
+		return &WalProblem{segment, 0, fmt.Sprintf("bad header: %v", err)}, nil
+	}
+	end := header.FileEnd
+	if end > fileSize {
+		end = fileSize
+	}
+
+	pos := int64(HeaderSize)
+	for pos < end {
+		entry := LogEntry{}
+		readSz, err := l.ReadEntry(f, pos, &entry)
+		if err != nil {
+			return &WalProblem{segment, pos, fmt.Sprintf("bad entry: %v", err)}, nil
+		}
+		if readSz <= 0 || pos+readSz > end {
+			return &WalProblem{segment, pos, fmt.Sprintf("entry of size[%v] exceeds file end[%v]", readSz, end)}, nil
+		}
+		err = fn(&ScannedEntry{Segment: segment, Offset: pos, Size: readSz, Entry: &entry})
+		if err != nil {
+			return nil, err
+		}
+		pos += readSz
+	}
+	if header.FileEnd > fileSize {
+		return &WalProblem{segment, pos, fmt.Sprintf("file end[%v] out of file size[%v]", header.FileEnd, fileSize)}, nil
+	}
+	return nil, nil
+}
+
+// ParseOp parses name of an Op case insensitively, e.g. modify
+func ParseOp(s string) (Op, error) {
+	for value, name := range Op_name {
+		if strings.EqualFold(name, s) {
+			return Op(value), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown op[%v]", s)
+}
+
+// OpFilter selects operations, empty fields match anything
+type OpFilter struct {
+	Key       string
+	Gid       string
+	MachineID string
+	Op        *Op
+}
+
+func (f *OpFilter) Match(op *LogOperation) bool {
+	if f == nil {
+		return true
+	}
+	if f.Key != "" && op.Key != f.Key {
+		return false
+	}
+	if f.Gid != "" && op.Gid != f.Gid {
+		return false
+	}
+	if f.MachineID != "" && op.MachineId != f.MachineID {
+		return false
+	}
+	if f.Op != nil && op.Op != int32(*f.Op) {
+		return false
+	}
+	return true
+}
+
+// Filter returns operations of entry matching f
+func (f *OpFilter) Filter(entry *LogEntry) []*LogOperation {
+	ops := []*LogOperation{}
+	for _, op := range entry.Ops {
+		if f.Match(op) {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+type WalStats struct {
+	Segments int
+	// entries having some operation matched
+	Entries int64
+	Ops     int64
+	// by name of Op
+	OpsByType map[string]int64
+	// size of entries having some operation matched
+	EntryBytes int64
+	// size of all segments
+	FileBytes int64
+	Keys      int
+	// where the scan skipped the rest of a segment
+	Problems []*WalProblem
+}
+
+// CollectWalStats counts operations matching filter, filter can be nil
+func CollectWalStats(filename string, l LogFormat, filter *OpFilter) (*WalStats, error) {
+	stats := WalStats{OpsByType: make(map[string]int64)}
+	segments, err := listSegments(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		f, err := OpenFile(segmentFileName(filename, segment), true)
+		if err != nil {
+			return nil, err
+		}
+		size, err := f.Seek(0, io.SeekEnd)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		stats.FileBytes += size
+	}
+	stats.Segments = len(segments)
+
+	keys := make(map[string]struct{})
+	stats.Problems, err = ScanWal(filename, l, func(e *ScannedEntry) error {
+		ops := filter.Filter(e.Entry)
+		if len(ops) == 0 {
+			return nil
+		}
+		stats.Entries++
+		stats.EntryBytes += e.Size
+		for _, op := range ops {
+			stats.Ops++
+			stats.OpsByType[Op(op.Op).String()]++
+			keys[op.Key] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Keys = len(keys)
+	return &stats, nil
+}
+
+// WalProblem is an inconsistency found in a wal
+type WalProblem struct {
+	Segment int64
+	Offset  int64
+	Reason  string
+}
+
+func (p *WalProblem) Error() string {
+	return fmt.Sprintf("segment[%v] offset[%v]: %v", p.Segment, p.Offset, p.Reason)
+}
+
+// CheckWal reads every entry, checking its crc where the format has one, and checks
+// fields of headers against the entries, returns the first problem found, nil if
+// there is none. error is returned if the check can't be done
+func CheckWal(filename string, l LogFormat) (*WalProblem, error) {
+	segments, err := listSegments(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("wal[%v] not exist", filename)
+	}
+
+	id := ""
+	prevEntryNum := int64(-1)
+	for _, segment := range segments {
+		problem, header, err := checkSegment(segmentFileName(filename, segment), l, segment)
+		if err != nil || problem != nil {
+			return problem, err
+		}
+		if id == "" {
+			id = header.Id
+		} else if header.Id != id {
+			return &WalProblem{segment, 0, fmt.Sprintf("id[%v] mismatches id[%v] of the first segment", header.Id, id)}, nil
+		}
+		if prevEntryNum >= 0 && header.StartNum != prevEntryNum {
+			return &WalProblem{segment, 0, fmt.Sprintf("start num[%v] mismatches entry num[%v] of the previous segment",
+				header.StartNum, prevEntryNum)}, nil
+		}
+		prevEntryNum = header.EntryNum
+	}
+	return nil, nil
+}
+
+func checkSegment(filename string, hint LogFormat, segment int64) (*WalProblem, *FileHeader, error) {
+	f, err := OpenFile(filename, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := DetectLogFormat(f, hint)
+	if err != nil {
+		return &WalProblem{segment, 0, fmt.Sprintf("unknown format: %v", err)}, nil, nil
+	}
+	header, err := l.ReadHeader(f)
+	if err != nil {
+		return &WalProblem{segment, 0, fmt.Sprintf("bad header: %v", err)}, nil, nil
+	}
+	if header.Segment != segment {
+		return &WalProblem{segment, 0, fmt.Sprintf("header of segment[%v]", header.Segment)}, header, nil
+	}
+	if header.FileEnd < HeaderSize || header.FileEnd > fileSize {
+		return &WalProblem{segment, 0, fmt.Sprintf("file end[%v] out of file size[%v]", header.FileEnd, fileSize)}, header, nil
+	}
+
+	num := header.StartNum
+	lastGid := ""
+	pos := int64(HeaderSize)
+	for pos < header.FileEnd {
+		entry := LogEntry{}
+		readSz, err := l.ReadEntry(f, pos, &entry)
+		if err != nil {
+			return &WalProblem{segment, pos, fmt.Sprintf("bad entry: %v", err)}, header, nil
+		}
+		if readSz <= 0 || pos+readSz > header.FileEnd {
+			return &WalProblem{segment, pos, fmt.Sprintf("entry of size[%v] exceeds file end[%v]", readSz, header.FileEnd)}, header, nil
+		}
+		for _, op := range entry.Ops {
+			num++
+			// written before operations were numbered
+			if op.Num != 0 && op.Num != num {
+				return &WalProblem{segment, pos, fmt.Sprintf("num[%v] of [%v], expected[%v]", op.Num, op.Gid, num)}, header, nil
+			}
+			lastGid = op.Gid
+		}
+		pos += readSz
+	}
+
+	if num != header.EntryNum {
+		return &WalProblem{segment, pos, fmt.Sprintf("entry num[%v] in header, found[%v]", header.EntryNum, num)}, header, nil
+	}
+	if num > header.StartNum && lastGid != header.LastEntryId {
+		return &WalProblem{segment, pos, fmt.Sprintf("last entry id[%v] in header, found[%v]", header.LastEntryId, lastGid)}, header, nil
+	}
+	if fileSize > header.FileEnd {
+		return &WalProblem{segment, header.FileEnd, fmt.Sprintf("%v bytes after file end, not recorded in header", fileSize-header.FileEnd)}, header, nil
+	}
+	return nil, header, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanWalAndStats(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 12)
+
+	offsets := []int64{}
+	_, err := ScanWal(walFileName, nil, func(e *ScannedEntry) error {
+		assert.Equal(t, 2, len(e.Entry.Ops))
+		offsets = append(offsets, e.Offset)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 12, len(offsets))
+	assert.Equal(t, int64(HeaderSize), offsets[0])
+
+	stats, err := CollectWalStats(walFileName, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, stats.Segments)
+	assert.Equal(t, int64(12), stats.Entries)
+	assert.Equal(t, int64(24), stats.Ops)
+	assert.Equal(t, int64(24), stats.OpsByType["Modify"])
+	assert.Equal(t, 1, stats.Keys)
+	assert.True(t, stats.FileBytes > stats.EntryBytes)
+
+	op, err := ParseOp("modify")
+	assert.Nil(t, err)
+	stats, err = CollectWalStats(walFileName, nil, &OpFilter{Key: "testKey", Op: &op, MachineID: "nobody"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stats.Ops)
+	_, err = ParseOp("nothing")
+	assert.NotNil(t, err)
+}
+
+func TestScanDamagedWal(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 12)
+
+	offsets := []int64{}
+	_, err := ScanWal(walFileName, nil, func(e *ScannedEntry) error {
+		if e.Segment == 0 {
+			offsets = append(offsets, e.Offset)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	// damage the second entry of the first segment and header of the last one
+	f, err := OpenFile(segmentFileName(walFileName, 0), false)
+	assert.Nil(t, err)
+	_, err = f.Seek(offsets[1]+8, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0xff, 0xff})
+	assert.Nil(t, err)
+	f.Close()
+	f, err = OpenFile(segmentFileName(walFileName, 2), false)
+	assert.Nil(t, err)
+	_, err = f.Seek(16, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0xff, 0xff})
+	assert.Nil(t, err)
+	f.Close()
+
+	scanned := map[int64]int{}
+	problems, err := ScanWal(walFileName, nil, func(e *ScannedEntry) error {
+		scanned[e.Segment]++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[int64]int{0: 1, 1: 5}, scanned)
+	assert.Equal(t, 2, len(problems))
+	assert.Equal(t, int64(0), problems[0].Segment)
+	assert.Equal(t, offsets[1], problems[0].Offset)
+	assert.Equal(t, int64(2), problems[1].Segment)
+	assert.Equal(t, int64(0), problems[1].Offset)
+
+	stats, err := CollectWalStats(walFileName, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), stats.Entries)
+	assert.Equal(t, 2, len(stats.Problems))
+}
+
+func TestCheckWal(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 4)
+
+	problem, err := CheckWal(walFileName, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, problem)
+
+	// damage the second entry
+	second := int64(0)
+	_, err = ScanWal(walFileName, nil, func(e *ScannedEntry) error {
+		if second == 0 && e.Offset > HeaderSize {
+			second = e.Offset
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	f, err := OpenFile(walFileName, false)
+	assert.Nil(t, err)
+	_, err = f.Seek(second+8, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0xff, 0xff})
+	assert.Nil(t, err)
+	f.Close()
+
+	problem, err = CheckWal(walFileName, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, problem)
+	assert.Equal(t, int64(0), problem.Segment)
+	assert.Equal(t, second, problem.Offset)
+}
+
+func TestCheckWalUnrecordedTail(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 1)
+
+	f, err := OpenFile(walFileName, false)
+	assert.Nil(t, err)
+	end, err := f.Seek(0, io.SeekEnd)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("tail"))
+	assert.Nil(t, err)
+	f.Close()
+
+	problem, err := CheckWal(walFileName, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, problem)
+	assert.Equal(t, end, problem.Offset)
+}
@@ -10,13 +10,27 @@ import (
 
 // TODO set json flag to output single line json
 
+const jsonLogVersion = 1
+
 type JsonLog struct {
 }
 
+func (l *JsonLog) ID() LogFormatID {
+	return JsonLogFormat
+}
+
+func (l *JsonLog) Name() string {
+	return "json"
+}
+
 // 写失败将破坏文件数据
 func (l *JsonLog) WriteHeader(f File, header *FileHeader) error {
 	const available = HeaderSize - 1
-	_, err := f.Seek(0, io.SeekStart)
+	header, err := sealHeader(header, l.ID(), jsonLogVersion)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
 	if err != nil {
 		return err
 	}
@@ -47,16 +61,14 @@ func (l *JsonLog) WriteHeader(f File, header *FileHeader) error {
 	return nil
 }
 
-// TODO: 更多校验
+// IsValidFile checks if f has a header written by JsonLog
 func (l *JsonLog) IsValidFile(f File) (bool, error) {
-	fSize, err := f.Seek(0, io.SeekEnd)
-	if err != nil {
+	has, err := hasHeader(f)
+	if err != nil || !has {
 		return false, err
 	}
-	if fSize < HeaderSize {
-		return false, nil
-	}
-	return true, nil
+	_, err = l.ReadHeader(f)
+	return err == nil, nil
 }
 
 func (l *JsonLog) ReadHeader(f File) (*FileHeader, error) {
@@ -80,6 +92,10 @@ func (l *JsonLog) ReadHeader(f File) (*FileHeader, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = checkHeader(&header, l.ID(), jsonLogVersion)
+	if err != nil {
+		return nil, err
+	}
 	return &header, nil
 }
 
@@ -143,6 +159,10 @@ func (l *JsonLog) ReadEntry(f File, pos int64, entry *LogEntry) (int64, error) {
 	return int64(readSz), nil
 }
 
+func init() {
+	RegisterLogFormat(&JsonLog{})
+}
+
 func _() {
 	var _ LogFormat = &JsonLog{}
 }
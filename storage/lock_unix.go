@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package storage
+
+import (
+	"syscall"
+)
+
+type unixFileLock struct {
+	fd int
+}
+
+func (l *unixFileLock) Unlock() error {
+	if l.fd == -1 {
+		return nil
+	}
+	err := syscall.Flock(l.fd, syscall.LOCK_UN)
+	if e := syscall.Close(l.fd); err == nil {
+		err = e
+	}
+	l.fd = -1
+	return err
+}
+
+// lockFile takes an exclusive flock on filename without waiting,
+// it's released when the process exits
+func lockFile(filename string) (fileLock, error) {
+	fd, err := syscall.Open(filename, syscall.O_CLOEXEC|syscall.O_CREAT|syscall.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		syscall.Close(fd)
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return &unixFileLock{fd: fd}, nil
+}
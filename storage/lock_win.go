@@ -0,0 +1,39 @@
+//go:build windows
+
+package storage
+
+import (
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+type winFileLock struct {
+	handle syscall.Handle
+}
+
+func (l *winFileLock) Unlock() error {
+	if l.handle == syscall.InvalidHandle {
+		return nil
+	}
+	err := syscall.CloseHandle(l.handle)
+	l.handle = syscall.InvalidHandle
+	return err
+}
+
+// lockFile opens filename without sharing, which fails while another one has it open
+func lockFile(filename string) (fileLock, error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return &winFileLock{handle: h}, nil
+}
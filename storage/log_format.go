@@ -1,11 +1,153 @@
 package storage
 
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+)
+
 const HeaderSize = 0x100
 
+// WalMagic marks a wal file, "DCWL"
+const WalMagic = 0x4c574344
+
+type LogFormatID int32
+
+const (
+	BinLogFormat   LogFormatID = 1
+	JsonLogFormat  LogFormatID = 2
+	FlateLogFormat LogFormatID = 3
+	CryptLogFormat LogFormatID = 4
+)
+
 type LogFormat interface {
+	ID() LogFormatID
+	Name() string
 	WriteHeader(f File, header *FileHeader) error
 	IsValidFile(f File) (bool, error)
 	ReadHeader(f File) (*FileHeader, error)
 	AppendEntry(f File, pos int64, entry *LogEntry) (int64, error)
 	ReadEntry(f File, pos int64, entry *LogEntry) (int64, error)
 }
+
+var logFormats = map[LogFormatID]LogFormat{}
+
+// RegisterLogFormat makes l available for detection, replacing the one with the same id
+func RegisterLogFormat(l LogFormat) {
+	logFormats[l.ID()] = l
+}
+
+// LogFormats returns all registered formats ordered by id
+func LogFormats() []LogFormat {
+	results := make([]LogFormat, 0, len(logFormats))
+	for _, l := range logFormats {
+		results = append(results, l)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID() < results[j].ID() })
+	return results
+}
+
+func LogFormatByName(name string) (LogFormat, error) {
+	for _, l := range logFormats {
+		if l.Name() == name {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown log format[%v]", name)
+}
+
+// used to create new wal files when no format is given
+func defaultLogFormat() LogFormat {
+	return &BinLog{}
+}
+
+// DetectLogFormat finds the format which wrote f, hint is preferred if it
+// matches, so that a configured instance is used, hint can be nil
+func DetectLogFormat(f File, hint LogFormat) (LogFormat, error) {
+	candidates := LogFormats()
+	if hint != nil {
+		candidates = append([]LogFormat{hint}, candidates...)
+	}
+	for _, l := range candidates {
+		valid, err := l.IsValidFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if valid {
+			if hint != nil && hint.ID() == l.ID() {
+				return hint, nil
+			}
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown format of wal file[%v]", f.Path())
+}
+
+func hasHeader(f File) (bool, error) {
+	fSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	return fSize >= HeaderSize, nil
+}
+
+func headerChecksum(header *FileHeader) (uint32, error) {
+	h := gogoproto.Clone(header).(*FileHeader)
+	h.Checksum = 0
+	data, err := h.Marshal()
+	if err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(data), nil
+}
+
+// sealHeader returns a copy of header, stamped with magic, format and checksum
+func sealHeader(header *FileHeader, format LogFormatID, version int32) (*FileHeader, error) {
+	h := gogoproto.Clone(header).(*FileHeader)
+	h.Magic = WalMagic
+	h.Format = int32(format)
+	h.Version = version
+	checksum, err := headerChecksum(h)
+	if err != nil {
+		return nil, err
+	}
+	h.Checksum = checksum
+	return h, nil
+}
+
+// legacyFormat tells if format was used before magic was introduced,
+// only its headers may have no magic
+func legacyFormat(format LogFormatID) bool {
+	return format == BinLogFormat || format == JsonLogFormat
+}
+
+// checkHeader verifies a header read by format, headers written by legacy
+// formats before magic was introduced are accepted as version 0
+func checkHeader(header *FileHeader, format LogFormatID, version int32) error {
+	if header.Magic == 0 && header.Checksum == 0 && header.Format == 0 {
+		if !legacyFormat(format) {
+			return fmt.Errorf("not a wal file of format[%v]", format)
+		}
+		return nil
+	}
+	if header.Magic != WalMagic {
+		return fmt.Errorf("not a wal file")
+	}
+	if header.Format != int32(format) {
+		return fmt.Errorf("wal file of format[%v], expected[%v]", header.Format, format)
+	}
+	if header.Version > version {
+		return fmt.Errorf("wal file of version[%v], supported up to[%v]", header.Version, version)
+	}
+	checksum, err := headerChecksum(header)
+	if err != nil {
+		return err
+	}
+	if checksum != header.Checksum {
+		return fmt.Errorf("header checksum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLogFormat(t *testing.T) {
+	for _, l := range []LogFormat{&BinLog{}, &JsonLog{}} {
+		wal := Wal{}
+		err := wal.Init(getWalFile(), l, false)
+		assert.Nil(t, err)
+		wal.Close()
+
+		f, err := OpenFile(walFileName, true)
+		assert.Nil(t, err)
+		detected, err := DetectLogFormat(f, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, l.ID(), detected.ID())
+
+		// a mismatched hint is ignored
+		detected, err = DetectLogFormat(f, &BinLog{})
+		assert.Nil(t, err)
+		assert.Equal(t, l.ID(), detected.ID())
+		f.Close()
+	}
+	delWalFile()
+}
+
+func TestWalInitDetectFormat(t *testing.T) {
+	defer delWalFile()
+	wal := Wal{}
+	err := wal.Init(getWalFile(), &JsonLog{}, false)
+	assert.Nil(t, err)
+	wal.Close()
+	appendTestEntries(t, &JsonLog{}, 10)
+
+	wal = Wal{}
+	err = wal.Init(walFileName, &BinLog{}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, JsonLogFormat, wal.Format().ID())
+	wal.Close()
+
+	assert.Equal(t, 10, countWalEntries(t, nil))
+}
+
+func TestHeaderChecksum(t *testing.T) {
+	defer delWalFile()
+	wal := Wal{}
+	err := wal.Init(getWalFile(), &JsonLog{}, false)
+	assert.Nil(t, err)
+	wal.Close()
+
+	f, err := OpenFile(walFileName, false)
+	assert.Nil(t, err)
+	header, err := (&JsonLog{}).ReadHeader(f)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(WalMagic), header.Magic)
+
+	// tamper with the header without resealing it
+	header.Id = "tampered"
+	err = checkHeader(header, JsonLogFormat, jsonLogVersion)
+	assert.NotNil(t, err)
+
+	header.Checksum, err = headerChecksum(header)
+	assert.Nil(t, err)
+	assert.Nil(t, checkHeader(header, JsonLogFormat, jsonLogVersion))
+	assert.NotNil(t, checkHeader(header, BinLogFormat, binLogVersion))
+	f.Close()
+}
+
+func TestLegacyHeader(t *testing.T) {
+	header := &FileHeader{Id: "legacy", FileEnd: HeaderSize}
+	assert.Nil(t, checkHeader(header, BinLogFormat, binLogVersion))
+	assert.Nil(t, checkHeader(header, JsonLogFormat, jsonLogVersion))
+	assert.NotNil(t, checkHeader(header, FlateLogFormat, flateLogVersion))
+	assert.NotNil(t, checkHeader(header, CryptLogFormat, cryptLogVersion))
+
+	header = &FileHeader{Magic: WalMagic + 1}
+	assert.NotNil(t, checkHeader(header, BinLogFormat, binLogVersion))
+}
+
+func TestLogFormatRegistry(t *testing.T) {
+	l, err := LogFormatByName("json")
+	assert.Nil(t, err)
+	assert.Equal(t, JsonLogFormat, l.ID())
+	_, err = LogFormatByName("unknown")
+	assert.NotNil(t, err)
+	assert.GreaterOrEqual(t, len(LogFormats()), 2)
+}
+
+func TestLegacyHeaderWithFlateHint(t *testing.T) {
+	t.Cleanup(delFile)
+	f := getFile(t)
+	defer f.Close()
+	// header written by BinLog before magic was introduced
+	data, err := (&FileHeader{Id: "legacy", FileEnd: HeaderSize}).Marshal()
+	assert.Nil(t, err)
+	buffer := [HeaderSize]byte{}
+	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(data)))
+	copy(buffer[4:], data)
+	_, err = f.Write(buffer[:])
+	assert.Nil(t, err)
+
+	for _, l := range []LogFormat{&FlateLog{}, &CryptLog{}} {
+		valid, err := l.IsValidFile(f)
+		assert.Nil(t, err)
+		assert.False(t, valid)
+		_, err = l.ReadHeader(f)
+		assert.NotNil(t, err)
+	}
+
+	l, err := DetectLogFormat(f, &FlateLog{})
+	assert.Nil(t, err)
+	assert.Equal(t, BinLogFormat, l.ID())
+	header, err := l.ReadHeader(f)
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy", header.Id)
+}
@@ -1,14 +1,46 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
+// errNotReady means the parent of an operation isn't replayed yet, other errors of
+// applying an operation are failures
+var errNotReady = errors.New("parent not replayed")
+
 type LogInput struct {
 	machineID string
 	w         *Wal
 	progress  *LogProgress
+	// progress is at the end of w, nothing to iterate
+	unchanged bool
+	// replayed to the end if nil
+	stop *StopPoint
+}
+
+// StopPoint is where replay of a log stops, the operation of Gid if it's set,
+// otherwise the operation of Num, which is included. zero means nothing is replayed
+type StopPoint struct {
+	Num int64
+	Gid string
+}
+
+func (s *StopPoint) String() string {
+	if s.Gid != "" {
+		return fmt.Sprintf("gid[%v]", s.Gid)
+	}
+	return fmt.Sprintf("num[%v]", s.Num)
+}
+
+// reached tells if progress is at or past the stop point
+func (s *StopPoint) reached(progress *LogProgress) bool {
+	if s.Gid != "" {
+		return progress.Gid == s.Gid
+	}
+	return progress.Num >= s.Num
 }
 
 type RunLogError struct {
@@ -26,9 +58,63 @@ func (e *RunLogError) Error() string {
 	return sb.String()
 }
 
+// CorruptLogError means a log can't be read past an entry, e.g. it failed crc check,
+// can't be decoded or failed verification
+type CorruptLogError struct {
+	MachineID string
+	Segment   int64
+	Offset    int64
+	// where entries of the segment end by its header, 0 if it's unknown
+	FileEnd int64
+	Err     error
+}
+
+func (e *CorruptLogError) Error() string {
+	return fmt.Sprintf("log of [%v] stopped at segment[%v] offset[%v]: %v", e.MachineID, e.Segment, e.Offset, e.Err)
+}
+
+func (e *CorruptLogError) Unwrap() error {
+	return e.Err
+}
+
+// persistent tells if the bad entry is within what the header says is written,
+// otherwise the log may be being copied by a sync client and is fine later
+func (e *CorruptLogError) persistent() bool {
+	return e.Offset < e.FileEnd
+}
+
+// BlockedReplay tells why the log of a participant stops advancing,
+// its next operation can't be applied yet
+type BlockedReplay struct {
+	MachineID string
+	// the operation parked
+	Op *LogOperation
+	// the parent it waits for, PrevNum of PrevMachineID or PrevGid
+	PrevMachineID string
+	PrevNum       int64
+	PrevGid       string
+	Reason        string
+	// when it's parked at Op first
+	Since time.Time
+}
+
+// BlockedFor is how long it has been blocked
+func (b *BlockedReplay) BlockedFor() time.Duration {
+	return time.Since(b.Since)
+}
+
+func (b *BlockedReplay) String() string {
+	return fmt.Sprintf("operation[%v] num[%v] on key[%v] waits for num[%v] of [%v] gid[%v] for %v: %v",
+		b.Op.Gid, b.Op.Num, b.Op.Key, b.PrevNum, b.PrevMachineID, b.PrevGid,
+		b.BlockedFor().Truncate(time.Second), b.Reason)
+}
+
 type RunLogResult struct {
 	status map[string]*LogProgress
 	err    *RunLogError
+	// by machine
+	blocked map[string]*BlockedReplay
+	failed  map[string]error
 }
 
 func (r *RunLogResult) Init(s map[string]*LogProgress, e *RunLogError) {
@@ -37,9 +123,22 @@ func (r *RunLogResult) Init(s map[string]*LogProgress, e *RunLogError) {
 }
 
 func (r *RunLogResult) Error() error {
+	if r.err == nil {
+		return nil
+	}
 	return r.err
 }
 
+// Blocked returns logs waiting for operations of others by machine
+func (r *RunLogResult) Blocked() map[string]*BlockedReplay {
+	return r.blocked
+}
+
+// Failed returns logs stopped by errors by machine, e.g. an entry failed verification
+func (r *RunLogResult) Failed() map[string]error {
+	return r.failed
+}
+
 func (r *RunLogResult) Process(machineID string) *LogProgress {
 	if progress, ok := r.status[machineID]; ok {
 		return progress
@@ -53,11 +152,27 @@ type RunLogWorker struct {
 	err              error
 	pendingOp        *LogOperation
 	pendingOpProcess *LogProgress
+	pendingReason    error // why pendingOp can't be applied
 	it               *WalIterator
 }
 
+// stopped tells if the stop point of its input is reached
+func (w *RunLogWorker) stopped() bool {
+	return w.input.stop != nil && w.input.stop.reached(w.progress)
+}
+
 type RunLogContext struct {
 	workers map[string]*RunLogWorker
+	// where operations are applied, a batch of runner's storage if it supports
+	s     NodeStorage
+	batch NodeBatch
+	// operations applied to batch, observed after it's committed
+	held []appliedOp
+}
+
+type appliedOp struct {
+	op       *LogOperation
+	replaced bool
 }
 
 func (c *RunLogContext) Init(i ...*LogInput) {
@@ -67,12 +182,19 @@ func (c *RunLogContext) Init(i ...*LogInput) {
 		if input == nil {
 			continue
 		}
-		it := input.w.IteratorOffset(input.progress.Offset)
-		c.workers[input.machineID] = &RunLogWorker{
-			input:    input,
-			progress: input.progress,
-			it:       it,
+		if input.unchanged {
+			// kept for progress, which others may depend on
+			c.workers[input.machineID] = &RunLogWorker{input: input, progress: input.progress}
+			continue
 		}
+		worker := RunLogWorker{input: input, progress: input.progress}
+		it, err := input.w.IteratorAfter(input.progress.Segment, input.progress.Offset, input.progress.Num)
+		if err != nil {
+			worker.err = &CorruptLogError{MachineID: input.machineID,
+				Segment: input.progress.Segment, Offset: input.progress.Offset, Err: err}
+		}
+		worker.it = it
+		c.workers[input.machineID] = &worker
 	}
 }
 
@@ -86,21 +208,51 @@ func (c *RunLogContext) Progress(machineID string) *LogProgress {
 type LogRunner struct {
 	machineID string
 	s         NodeStorage
+	clock     *HLC // observes timestamps of operations replayed if set
+	// blocked logs of the last run, to tell how long they have been blocked
+	blocked map[string]*BlockedReplay
+	// called with every operation applied if set
+	observer AppliedFunc
 }
 
+// AppliedFunc observes an operation applied, replaced tells if it replaced its parent,
+// otherwise it's added as a new leaf
+type AppliedFunc func(op *LogOperation, replaced bool)
+
 func (r *LogRunner) Init(machineID string, s NodeStorage) error {
 	r.machineID = machineID
 	r.s = s
 	return nil
 }
 
-func (r *LogRunner) runLogInner(c *RunLogContext, progress *LogProgress, logOp *LogOperation) bool {
+func (r *LogRunner) SetClock(c *HLC) {
+	r.clock = c
+}
+
+func (r *LogRunner) SetObserver(f AppliedFunc) {
+	r.observer = f
+}
+
+func (r *LogRunner) applied(c *RunLogContext, op *LogOperation, replaced bool) {
+	if r.observer == nil {
+		return
+	}
+	if c.batch != nil {
+		c.held = append(c.held, appliedOp{op: op, replaced: replaced})
+		return
+	}
+	r.observer(op, replaced)
+}
+
+// runLogInner applies logOp, returns errNotReady if it can't be applied now
+func (r *LogRunner) runLogInner(c *RunLogContext, progress *LogProgress, logOp *LogOperation) error {
 	if logOp.PrevNum == 0 {
 		record := DBRecord{
 			Key:                logOp.Key,
 			Value:              logOp.Value,
 			MachineID:          logOp.MachineId,
 			PrevMachineID:      "",
+			Segment:            progress.Segment,
 			Offset:             progress.Offset,
 			Seq:                logOp.Seq,
 			CurrentLogGid:      logOp.Gid,
@@ -110,28 +262,33 @@ func (r *LogRunner) runLogInner(c *RunLogContext, progress *LogProgress, logOp *
 			MachineChangeCount: logOp.Changes,
 			Num:                logOp.Num,
 			PrevNum:            logOp.PrevNum,
+			HLC:                logOp.Hlc,
 		}
-		err := r.s.Add(&record)
+		err := c.s.Add(&record)
 		if err != nil {
 			logger.Error("add leaf[%v] [%v] failed[%v]", record.Key, record.CurrentLogGid, err)
-			return false
+			return fmt.Errorf("add leaf failed[%v]", err)
 		}
-		return true
+		r.applied(c, logOp, false)
+		return nil
 	}
 
-	if logOp.PrevNum > c.Progress(logOp.PrevMachineId).Num {
-		return false
+	if replayed := c.Progress(logOp.PrevMachineId).Num; logOp.PrevNum > replayed {
+		return fmt.Errorf("%w, log of [%v] is replayed to num[%v]", errNotReady, logOp.PrevMachineId, replayed)
 	}
 
-	parent, err := r.s.GetByGid(logOp.PrevGid)
-	if err != nil {
-		return false
+	// the parent is replayed, it's replaced by another child if not found
+	parent, err := c.s.GetByGid(logOp.PrevGid)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.Error("get parent[%v] of [%v] failed[%v]", logOp.PrevGid, logOp.Gid, err)
+		return fmt.Errorf("get parent failed[%v]", err)
 	}
-	if parent != nil {
+	if err == nil {
 		record := DBRecord{
 			Key:                logOp.Key,
 			Value:              logOp.Value,
 			MachineID:          logOp.MachineId,
+			Segment:            progress.Segment,
 			Offset:             progress.Offset,
 			PrevMachineID:      logOp.PrevMachineId,
 			Seq:                logOp.Seq,
@@ -142,19 +299,22 @@ func (r *LogRunner) runLogInner(c *RunLogContext, progress *LogProgress, logOp *
 			MachineChangeCount: logOp.Changes,
 			Num:                logOp.Num,
 			PrevNum:            logOp.PrevNum,
+			HLC:                logOp.Hlc,
 		}
-		err := r.s.Replace(parent.CurrentLogGid, &record)
+		err := c.s.Replace(parent.CurrentLogGid, &record)
 		if err != nil {
 			logger.Error("update leaf of [%v] [%v]->[%v] failed", parent.Key, parent.CurrentLogGid, record.CurrentLogGid)
-			return false
+			return fmt.Errorf("update leaf failed[%v]", err)
 		}
-		return true
+		r.applied(c, logOp, true)
+		return nil
 	}
 
 	record := DBRecord{
 		Key:                logOp.Key,
 		Value:              logOp.Value,
 		MachineID:          logOp.MachineId,
+		Segment:            progress.Segment,
 		Offset:             progress.Offset,
 		PrevMachineID:      logOp.PrevMachineId,
 		Seq:                logOp.Seq,
@@ -165,52 +325,95 @@ func (r *LogRunner) runLogInner(c *RunLogContext, progress *LogProgress, logOp *
 		MachineChangeCount: logOp.Changes,
 		Num:                logOp.Num,
 		PrevNum:            logOp.PrevNum,
+		HLC:                logOp.Hlc,
 	}
-	err = r.s.Add(&record)
+	err = c.s.Add(&record)
 	if err != nil {
 		logger.Error("add leaf of key[%v] [%v] failed", record.Key, record.CurrentLogGid)
-		return false
+		return fmt.Errorf("add leaf failed[%v]", err)
 	}
-	return true
+	r.applied(c, logOp, false)
+	return nil
 }
 
 func (r *LogRunner) tryAdvance(c *RunLogContext, worker *RunLogWorker) bool {
+	if worker.err != nil || worker.it == nil || worker.stopped() {
+		return false
+	}
 	count := 0
 
 	if worker.pendingOp != nil {
-		if !r.runLogInner(c, worker.pendingOpProcess, worker.pendingOp) {
+		if err := r.runLogInner(c, worker.pendingOpProcess, worker.pendingOp); err != nil {
+			r.park(worker, worker.pendingOp, worker.pendingOpProcess, err)
 			return false
 		}
 		worker.progress = worker.pendingOpProcess
+		worker.progress.AppliedAt = time.Now()
 		worker.pendingOp = nil
 		worker.pendingOpProcess = nil
+		worker.pendingReason = nil
 		count++
 	}
 
-	for worker.it.Next() {
+	for !worker.stopped() && worker.it.Next() {
 		logOp := worker.it.LogOp()
+		if r.clock != nil {
+			r.clock.Update(HLCTimestamp(logOp.Hlc))
+		}
 		currentProcess := LogProgress{
-			Num:    logOp.Num,
-			Offset: worker.it.Offset(),
-			Gid:    logOp.Gid,
+			Num:     logOp.Num,
+			Segment: worker.it.Segment(),
+			Offset:  worker.it.Offset(),
+			Gid:     logOp.Gid,
 		}
-		if !r.runLogInner(c, &currentProcess, logOp) {
-			worker.pendingOp = logOp
-			worker.pendingOpProcess = &currentProcess
+		if err := r.runLogInner(c, &currentProcess, logOp); err != nil {
+			r.park(worker, logOp, &currentProcess, err)
 			return count > 0
 		}
+		currentProcess.AppliedAt = time.Now()
 		worker.progress = &currentProcess
 		count++
 	}
+	if err := worker.it.Err(); err != nil {
+		// e.g. an entry failed verification, don't go past it
+		worker.err = &CorruptLogError{MachineID: worker.input.machineID,
+			Segment: worker.it.Segment(), Offset: worker.it.Offset(), FileEnd: worker.it.End(), Err: err}
+	}
 	return count > 0
 }
 
+// park keeps logOp to retry if its parent isn't replayed yet, or stops the worker
+// on failures, it's retried in the next run
+func (r *LogRunner) park(worker *RunLogWorker, logOp *LogOperation, progress *LogProgress, err error) {
+	if !errors.Is(err, errNotReady) {
+		worker.err = fmt.Errorf("log of [%v] stopped at num[%v]: %v", worker.input.machineID, logOp.Num, err)
+		worker.pendingOp = nil
+		worker.pendingOpProcess = nil
+		worker.pendingReason = nil
+		return
+	}
+	worker.pendingOp = logOp
+	worker.pendingOpProcess = progress
+	worker.pendingReason = err
+}
+
 func (r *LogRunner) Run(i ...*LogInput) (*RunLogResult, error) {
 	if len(i) == 0 {
 		return nil, fmt.Errorf("empty input")
 	}
 	c := RunLogContext{}
 	c.Init(i...)
+	c.s = r.s
+	var batch NodeBatch
+	if b, ok := r.s.(BatchNodeStorage); ok {
+		var err error
+		batch, err = b.Begin()
+		if err != nil {
+			return nil, err
+		}
+		c.s = batch
+		c.batch = batch
+	}
 
 	blockNum := 0
 	for {
@@ -229,15 +432,62 @@ func (r *LogRunner) Run(i ...*LogInput) (*RunLogResult, error) {
 		}
 	}
 
-	result := RunLogResult{status: make(map[string]*LogProgress)}
+	result := RunLogResult{status: make(map[string]*LogProgress),
+		blocked: make(map[string]*BlockedReplay), failed: make(map[string]error)}
 	for _, worker := range c.workers {
+		machineID := worker.input.machineID
 		if worker.err != nil {
 			if result.err == nil {
 				result.err = &RunLogError{}
 			}
 			result.err.errs = append(result.err.errs, worker.err)
+			result.failed[machineID] = worker.err
+		}
+		if worker.pendingOp != nil {
+			result.blocked[machineID] = r.blockedReplay(machineID, worker)
+		}
+		result.status[machineID] = worker.progress
+	}
+	if batch != nil {
+		// progress of logs advanced is written once
+		progress := []*LogProgress{}
+		for _, worker := range c.workers {
+			if worker.progress != worker.input.progress {
+				copied := *worker.progress
+				copied.MachineID = worker.input.machineID
+				progress = append(progress, &copied)
+			}
+		}
+		if err := batch.Commit(progress); err != nil {
+			return nil, fmt.Errorf("commit batch failed[%v]", err)
+		}
+		for _, a := range c.held {
+			r.observer(a.op, a.replaced)
 		}
-		result.status[worker.input.machineID] = worker.progress
 	}
+	r.blocked = result.blocked
 	return &result, nil
 }
+
+// blockedReplay describes the worker parked at pendingOp, it has been blocked since
+// the last run if it was parked at the same operation
+func (r *LogRunner) blockedReplay(machineID string, worker *RunLogWorker) *BlockedReplay {
+	op := worker.pendingOp
+	since := time.Now()
+	last, ok := r.blocked[machineID]
+	first := !ok || last.Op.Gid != op.Gid
+	if !first {
+		since = last.Since
+	}
+	reason := ""
+	if worker.pendingReason != nil {
+		reason = worker.pendingReason.Error()
+	}
+	b := &BlockedReplay{MachineID: machineID, Op: op,
+		PrevMachineID: op.PrevMachineId, PrevNum: op.PrevNum, PrevGid: op.PrevGid,
+		Reason: reason, Since: since}
+	if first {
+		logger.Warn("log of [%v] is blocked, %v", machineID, b)
+	}
+	return b
+}
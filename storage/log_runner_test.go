@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStorage fails to look up parents like a broken disk
+type failingStorage struct {
+	NodeStorageImpl
+}
+
+func (s *failingStorage) GetByGid(gid string) (*DBRecord, error) {
+	return nil, errors.New("disk I/O error")
+}
+
+// prepareRunnerWal writes a fork of gid1, segments roll over every segmentEntries if > 0
+func prepareRunnerWal(t *testing.T, segmentEntries int64) *Wal {
+	w := Wal{}
+	err := w.Init(walFileName, nil, false)
+	assert.Nil(t, err)
+	if segmentEntries > 0 {
+		w.SetSegmentLimit(0, segmentEntries)
+	}
+	ops := []*LogOperation{
+		{Op: int32(Op_Modify), Key: "testKey", Value: "v1", Gid: "gid1", Num: 1, MachineId: "machine0"},
+		{Op: int32(Op_Modify), Key: "testKey", Value: "v2", Gid: "gid2", Num: 2, MachineId: "machine0",
+			PrevGid: "gid1", PrevNum: 1, PrevMachineId: "machine0"},
+		{Op: int32(Op_Modify), Key: "testKey", Value: "v3", Gid: "gid3", Num: 3, MachineId: "machine0",
+			PrevGid: "gid1", PrevNum: 1, PrevMachineId: "machine0"},
+	}
+	for _, op := range ops {
+		err = w.AppendRaw(op)
+		assert.Nil(t, err)
+	}
+	return &w
+}
+
+func TestLogRunnerForkOnSqlite(t *testing.T) {
+	t.Cleanup(delWalFile)
+	t.Cleanup(delDBFile)
+	delWalFile()
+	w := prepareRunnerWal(t, 0)
+	defer w.Close()
+	s := getDB(t)
+	defer s.Close()
+
+	r := LogRunner{}
+	err := r.Init("machine1", s)
+	assert.Nil(t, err)
+	result, err := r.Run(&LogInput{machineID: "machine0", w: w, progress: newLogProgress("machine0")})
+	assert.Nil(t, err)
+	assert.Nil(t, result.Error())
+	assert.Equal(t, 0, len(result.Blocked()))
+	assert.Equal(t, int64(3), result.Process("machine0").Num)
+
+	records, err := s.GetByKey("testKey")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	processes, err := s.Processes()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(processes))
+	assert.Equal(t, int64(3), processes[0].Num)
+}
+
+func TestLogRunnerForkAcrossSegments(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+	w := prepareRunnerWal(t, 1)
+	defer w.Close()
+	s := NodeStorageImpl{}
+	s.Init()
+
+	r := LogRunner{}
+	err := r.Init("machine1", &s)
+	assert.Nil(t, err)
+	result, err := r.Run(&LogInput{machineID: "machine0", w: w, progress: newLogProgress("machine0")})
+	assert.Nil(t, err)
+	assert.Nil(t, result.Error())
+	progress := result.Process("machine0")
+	assert.Equal(t, int64(3), progress.Num)
+	assert.Equal(t, int64(2), progress.Segment)
+
+	// gid3 is added as a new leaf, as its parent is replaced by gid2
+	record, err := s.GetByGid("gid3")
+	assert.Nil(t, err)
+	assert.Equal(t, progress.Segment, record.Segment)
+	assert.Equal(t, progress.Offset, record.Offset)
+	record, err = s.GetByGid("gid2")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), record.Segment)
+}
+
+func TestLogRunnerStorageFailure(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+	w := prepareRunnerWal(t, 0)
+	defer w.Close()
+	s := failingStorage{}
+	s.Init()
+
+	r := LogRunner{}
+	err := r.Init("machine1", &s)
+	assert.Nil(t, err)
+	result, err := r.Run(&LogInput{machineID: "machine0", w: w, progress: newLogProgress("machine0")})
+	assert.Nil(t, err)
+	assert.NotNil(t, result.Error())
+	assert.NotNil(t, result.Failed()["machine0"])
+	assert.Equal(t, 0, len(result.Blocked()))
+	assert.Equal(t, int64(1), result.Process("machine0").Num)
+}
+
+// failingCommitStorage begins batches which fail to commit
+type failingCommitStorage struct {
+	*SqliteAdapter
+}
+
+type failingCommitBatch struct {
+	NodeBatch
+}
+
+func (s *failingCommitStorage) Begin() (NodeBatch, error) {
+	b, err := s.SqliteAdapter.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &failingCommitBatch{b}, nil
+}
+
+func (b *failingCommitBatch) Commit(progress []*LogProgress) error {
+	if err := b.Rollback(); err != nil {
+		return err
+	}
+	return errors.New("disk I/O error")
+}
+
+func TestLogRunnerObserveAfterCommit(t *testing.T) {
+	t.Cleanup(delWalFile)
+	t.Cleanup(delDBFile)
+	delWalFile()
+	w := prepareRunnerWal(t, 0)
+	defer w.Close()
+	s := getDB(t)
+	defer s.Close()
+
+	observed := []string{}
+	r := LogRunner{}
+	err := r.Init("machine1", &failingCommitStorage{s})
+	assert.Nil(t, err)
+	r.SetObserver(func(op *LogOperation, replaced bool) { observed = append(observed, op.Gid) })
+	_, err = r.Run(&LogInput{machineID: "machine0", w: w, progress: newLogProgress("machine0")})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(observed))
+	records, err := s.GetByKey("testKey")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+
+	r = LogRunner{}
+	err = r.Init("machine1", s)
+	assert.Nil(t, err)
+	r.SetObserver(func(op *LogOperation, replaced bool) { observed = append(observed, op.Gid) })
+	_, err = r.Run(&LogInput{machineID: "machine0", w: w, progress: newLogProgress("machine0")})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gid1", "gid2", "gid3"}, observed)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package storage
+
+// OpenMmapFile opens filename readonly, mmap is not used on this platform yet
+func OpenMmapFile(filename string) (File, error) {
+	return OpenFile(filename, true)
+}
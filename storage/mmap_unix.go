@@ -1,3 +1,167 @@
 //go:build linux
 
 package storage
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"syscall"
+)
+
+// MmapFile is a readonly File backed by mmap, for logs of other participants,
+// which are read a lot but never written by us.
+// the file is remapped when it's found grown, it's expected to only grow while
+// mapped, as its owner only appends to it. if it's truncated in place, e.g. by a
+// sync client, reading the mapping beyond its end raises SIGBUS, which is turned
+// into an error by recoverFault
+type MmapFile struct {
+	fd       int
+	filename string
+	data     []byte
+	pos      int64
+}
+
+// Init ...
+func (f *MmapFile) Init(filename string) error {
+	fd, err := syscall.Open(filename, syscall.O_CLOEXEC|syscall.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	f.fd = fd
+	f.filename = filename
+	f.data = nil
+	f.pos = 0
+	if _, err := f.remap(); err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+// OpenMmapFile opens filename readonly by mmap
+func OpenMmapFile(filename string) (File, error) {
+	f := MmapFile{}
+	if err := f.Init(filename); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// remap maps the whole file again if it has grown, returns the file size
+func (f *MmapFile) remap() (int64, error) {
+	stat := syscall.Stat_t{}
+	if err := syscall.Fstat(f.fd, &stat); err != nil {
+		return 0, err
+	}
+	size := stat.Size
+	if size <= int64(len(f.data)) {
+		return size, nil
+	}
+	if int64(int(size)) != size {
+		return 0, fmt.Errorf("file[%v] too large to map", f.filename)
+	}
+	data, err := syscall.Mmap(f.fd, 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return 0, err
+	}
+	// slices of the old mapping are not kept by readers
+	if f.data != nil {
+		if err := syscall.Munmap(f.data); err != nil {
+			logger.Error("unmap file[%v] failed[%v]", f.filename, err)
+		}
+	}
+	f.data = data
+	return size, nil
+}
+
+func (f *MmapFile) Close() error {
+	var firstErr error
+	if f.data != nil {
+		firstErr = syscall.Munmap(f.data)
+		f.data = nil
+	}
+	if f.fd != -1 {
+		if err := syscall.Close(f.fd); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		f.fd = -1
+	}
+	return firstErr
+}
+
+// slice returns n bytes at offset without copying, valid until the next call to f
+func (f *MmapFile) slice(offset int64, n int) ([]byte, error) {
+	if offset < 0 || n < 0 {
+		return nil, fmt.Errorf("invalid range")
+	}
+	end := offset + int64(n)
+	if end > int64(len(f.data)) {
+		if _, err := f.remap(); err != nil {
+			return nil, err
+		}
+		if end > int64(len(f.data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+	return f.data[offset:end], nil
+}
+
+func (f *MmapFile) Read(p []byte) (n int, err error) {
+	defer recoverFault(&err, debug.SetPanicOnFault(true))
+	if f.pos+int64(len(p)) > int64(len(f.data)) {
+		if _, err := f.remap(); err != nil {
+			return 0, err
+		}
+	}
+	if f.pos >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, f.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *MmapFile) Write(p []byte) (n int, err error) {
+	return 0, fmt.Errorf("write to readonly file[%v]", f.filename)
+}
+
+func (f *MmapFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = f.pos + offset
+	case io.SeekEnd:
+		size, err := f.remap()
+		if err != nil {
+			return 0, err
+		}
+		pos = size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+func (f *MmapFile) Flush() error {
+	return nil
+}
+
+func (f *MmapFile) Truncate(size int64) error {
+	return fmt.Errorf("truncate readonly file[%v]", f.filename)
+}
+
+func (f *MmapFile) Path() string {
+	return f.filename
+}
+
+func _() {
+	var _ File = &MmapFile{}
+	var _ sliceReader = &MmapFile{}
+}
@@ -0,0 +1,105 @@
+//go:build linux
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMmapFileRead(t *testing.T) {
+	t.Cleanup(delFile)
+	w := getFile(t)
+	defer w.Close()
+	_, err := w.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	f, err := OpenMmapFile(_fileName)
+	assert.Nil(t, err)
+	defer f.Close()
+	buf := make([]byte, 5)
+	n, err := f.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "hello", string(buf))
+	_, err = f.Read(buf)
+	assert.Equal(t, io.EOF, err)
+
+	// grown file is remapped
+	_, err = w.Write([]byte(" world"))
+	assert.Nil(t, err)
+	size, err := f.Seek(0, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), size)
+	_, err = f.Seek(6, io.SeekStart)
+	assert.Nil(t, err)
+	n, err = f.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+	old, err := f.(sliceReader).slice(0, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(old))
+
+	_, err = f.(sliceReader).slice(6, 10)
+	assert.NotNil(t, err)
+	_, err = f.Write([]byte("x"))
+	assert.NotNil(t, err)
+}
+
+func TestWalInitMmap(t *testing.T) {
+	t.Cleanup(delWalFile)
+	for _, l := range []LogFormat{&BinLog{}, &JsonLog{}, &FlateLog{}} {
+		w := Wal{}
+		err := w.Init(getWalFile(), l, false)
+		assert.Nil(t, err)
+		w.SetSegmentLimit(0, 3)
+		for i := 0; i < 5; i++ {
+			_, _, err = w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(i)})
+			assert.Nil(t, err)
+		}
+
+		r := Wal{}
+		err = r.InitMmap(walFileName, nil)
+		assert.Nil(t, err)
+		i := r.Iterator()
+		values := []string{}
+		for i.Next() {
+			values = append(values, i.LogOp().Value)
+		}
+		assert.Nil(t, i.Err())
+		assert.Equal(t, []string{"0", "1", "2", "3", "4"}, values)
+		r.Close()
+		w.Close()
+	}
+}
+
+func TestMmapFileTruncated(t *testing.T) {
+	t.Cleanup(delFile)
+	l := &BinLog{}
+	w := getFile(t)
+	defer w.Close()
+	err := l.WriteHeader(w, &FileHeader{FileEnd: HeaderSize})
+	assert.Nil(t, err)
+	_, err = l.AppendEntry(w, -1, &LogEntry{Ops: []*LogOperation{{Op: int32(Op_Modify), Key: "key", Value: "value"}}})
+	assert.Nil(t, err)
+
+	f, err := OpenMmapFile(_fileName)
+	assert.Nil(t, err)
+	defer f.Close()
+	entry := LogEntry{}
+	_, err = l.ReadEntry(f, HeaderSize, &entry)
+	assert.Nil(t, err)
+
+	// truncated in place while mapped, reading it raises SIGBUS
+	err = w.Truncate(0)
+	assert.Nil(t, err)
+	_, err = l.ReadEntry(f, HeaderSize, &entry)
+	assert.NotNil(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Read(make([]byte, 4))
+	assert.NotNil(t, err)
+}
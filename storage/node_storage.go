@@ -2,11 +2,20 @@ package storage
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
 )
 
+var (
+	// ErrNotFound means the record doesn't exist, other errors are failures of the storage
+	ErrNotFound = errors.New("record not found")
+	// ErrExists means a record of the same gid exists
+	ErrExists = errors.New("record exists")
+)
+
 type ReadOnlyNodeStorage interface {
 	GetByKey(key string) ([]*DBRecord, error)
+	// GetByGid returns ErrNotFound if there is no record of gid
 	GetByGid(gid string) (*DBRecord, error)
 	AllNodes() ([]*DBRecord, error)
 }
@@ -18,6 +27,23 @@ type NodeStorage interface {
 	Merge(other ReadOnlyNodeStorage) error
 }
 
+// BatchNodeStorage applies writes of a run in a batch, which is cheaper than one by one
+type BatchNodeStorage interface {
+	NodeStorage
+	Begin() (NodeBatch, error)
+}
+
+// NodeBatch is a storage whose writes are visible to itself only, until they are committed
+// along with progress of logs, or discarded by Rollback
+type NodeBatch interface {
+	NodeStorage
+	// Remove deletes the record of gid, e.g. it's replaced by a record added separately
+	Remove(gid string) error
+	// Commit writes progress and commits, the batch is rolled back if it fails
+	Commit(progress []*LogProgress) error
+	Rollback() error
+}
+
 type NodeStorageImpl struct {
 	l *list.List
 
@@ -46,7 +72,7 @@ func (n *NodeStorageImpl) getByGidInternal(gid string) *list.Element {
 func (n *NodeStorageImpl) addNodeInternal(record *DBRecord) error {
 	result := n.getByGidInternal(record.CurrentLogGid)
 	if result != nil {
-		return fmt.Errorf("node exists")
+		return ErrExists
 	}
 	e := n.l.PushBack(record)
 	elements, ok := n.keyIndex[record.Key]
@@ -93,7 +119,7 @@ func (n *NodeStorageImpl) GetByGid(gid string) (*DBRecord, error) {
 	if result != nil {
 		return result.Value.(*DBRecord), nil
 	}
-	return nil, nil
+	return nil, ErrNotFound
 }
 
 func (n *NodeStorageImpl) Add(record *DBRecord) error {
@@ -103,12 +129,12 @@ func (n *NodeStorageImpl) Add(record *DBRecord) error {
 func (n *NodeStorageImpl) Replace(old string, new *DBRecord) error {
 	e := n.getByGidInternal(old)
 	if e == nil {
-		return fmt.Errorf("old not exist")
+		return fmt.Errorf("old %w", ErrNotFound)
 	}
 
 	e1 := n.getByGidInternal(new.CurrentLogGid)
 	if e1 != nil {
-		return fmt.Errorf("new node already exist")
+		return fmt.Errorf("new %w", ErrExists)
 	}
 
 	if e.Value.(*DBRecord).Key != new.Key {
@@ -1,3 +1,40 @@
 package storage
 
-// TODO test NodeStorageImpl and SqliteAdapter together
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testNodeStorage(t *testing.T, s NodeStorage) {
+	_, err := s.GetByGid("nothing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	err = s.Add(&DBRecord{Key: "testKey", Value: "v1", CurrentLogGid: "gid1", MachineID: "machine0", Num: 1})
+	assert.Nil(t, err)
+	record, err := s.GetByGid("gid1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", record.Value)
+
+	err = s.Replace("gid1", &DBRecord{Key: "testKey", Value: "v2", CurrentLogGid: "gid2", MachineID: "machine0", Num: 2})
+	assert.Nil(t, err)
+	_, err = s.GetByGid("gid1")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	record, err = s.GetByGid("gid2")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", record.Value)
+}
+
+func TestNodeStorageImpl(t *testing.T) {
+	s := NodeStorageImpl{}
+	s.Init()
+	testNodeStorage(t, &s)
+}
+
+func TestNodeStorageSqlite(t *testing.T) {
+	t.Cleanup(delDBFile)
+	s := getDB(t)
+	defer s.Close()
+	testNodeStorage(t, s)
+}
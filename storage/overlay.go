@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OverlayStorage is a copy-on-write layer over a read-only storage, writes are kept
+// in the overlay and the base is never changed
+type OverlayStorage struct {
+	base    ReadOnlyNodeStorage
+	added   *NodeStorageImpl
+	removed map[string]bool // gid of base records replaced
+}
+
+func (o *OverlayStorage) Init(base ReadOnlyNodeStorage) {
+	o.base = base
+	o.added = &NodeStorageImpl{}
+	o.added.Init()
+	o.removed = make(map[string]bool)
+}
+
+func (o *OverlayStorage) GetByKey(key string) ([]*DBRecord, error) {
+	records, err := o.base.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*DBRecord, 0, len(records))
+	for _, record := range records {
+		if record != nil && !o.removed[record.CurrentLogGid] {
+			results = append(results, record)
+		}
+	}
+	added, err := o.added.GetByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, added...), nil
+}
+
+func (o *OverlayStorage) GetByGid(gid string) (*DBRecord, error) {
+	record, err := o.added.GetByGid(gid)
+	if err == nil || !errors.Is(err, ErrNotFound) {
+		return record, err
+	}
+	if o.removed[gid] {
+		return nil, ErrNotFound
+	}
+	return o.base.GetByGid(gid)
+}
+
+func (o *OverlayStorage) AllNodes() ([]*DBRecord, error) {
+	records, err := o.base.AllNodes()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*DBRecord, 0, len(records))
+	for _, record := range records {
+		if record != nil && !o.removed[record.CurrentLogGid] {
+			results = append(results, record)
+		}
+	}
+	added, err := o.added.AllNodes()
+	if err != nil {
+		return nil, err
+	}
+	return append(results, added...), nil
+}
+
+func (o *OverlayStorage) Add(record *DBRecord) error {
+	_, err := o.GetByGid(record.CurrentLogGid)
+	if err == nil {
+		return ErrExists
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return o.added.Add(record)
+}
+
+func (o *OverlayStorage) Replace(old string, new *DBRecord) error {
+	record, err := o.GetByGid(old)
+	if err != nil {
+		return fmt.Errorf("old %w", err)
+	}
+	if _, err := o.GetByGid(new.CurrentLogGid); err == nil {
+		return fmt.Errorf("new %w", ErrExists)
+	}
+	if record.Key != new.Key {
+		return fmt.Errorf("key not match")
+	}
+
+	if _, err := o.added.GetByGid(old); err == nil {
+		return o.added.Replace(old, new)
+	}
+	if err := o.added.Add(new); err != nil {
+		return err
+	}
+	o.removed[old] = true
+	return nil
+}
+
+func (o *OverlayStorage) Merge(other ReadOnlyNodeStorage) error {
+	return fmt.Errorf("unsupported")
+}
+
+func _() {
+	var _ NodeStorage = &OverlayStorage{}
+}
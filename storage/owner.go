@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const LockFileName = ".lock"
+const OwnerFileName = "owner.json"
+
+var errLocked = errors.New("locked by another process")
+
+type fileLock interface {
+	Unlock() error
+}
+
+// OwnerInfo tells who is using a personal directory
+type OwnerInfo struct {
+	Host      string    `json:"host"`
+	Pid       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (o *OwnerInfo) String() string {
+	return fmt.Sprintf("pid[%v] on host[%v] since[%v]", o.Pid, o.Host, o.StartedAt.Format(time.RFC3339))
+}
+
+func (o *OwnerInfo) same(other *OwnerInfo) bool {
+	return o.Host == other.Host && o.Pid == other.Pid && o.StartedAt.Equal(other.StartedAt)
+}
+
+func getLockFilePath(personalPath string) string {
+	return path.Join(personalPath, LockFileName)
+}
+
+func getOwnerFilePath(personalPath string) string {
+	return path.Join(personalPath, OwnerFileName)
+}
+
+// readOwner returns nil if the directory is not owned
+func readOwner(filename string) (*OwnerInfo, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	owner := OwnerInfo{}
+	err = json.Unmarshal(data, &owner)
+	if err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// ownership of a personal directory, the lock stops processes on the same host,
+// the owner file, which is synced, stops other hosts
+type ownership struct {
+	lock      fileLock
+	ownerFile string
+	me        OwnerInfo
+}
+
+// acquireOwnership fails if personalPath is used by another process,
+// takeover ignores the owner recorded by another host, which may be stale
+func acquireOwnership(personalPath string, takeover bool) (*ownership, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	ownerFile := getOwnerFilePath(personalPath)
+
+	lock, err := lockFile(getLockFilePath(personalPath))
+	if err != nil {
+		if err == errLocked {
+			owner, _ := readOwner(ownerFile)
+			if owner != nil {
+				return nil, fmt.Errorf("participant directory[%v] is owned by %v", personalPath, owner)
+			}
+			return nil, fmt.Errorf("participant directory[%v] is owned by another process", personalPath)
+		}
+		return nil, err
+	}
+
+	owner, err := readOwner(ownerFile)
+	if err != nil {
+		logger.Warn("read owner of [%v] failed[%v], overwrite it", personalPath, err)
+	}
+	if owner != nil {
+		if owner.Host != host && !takeover {
+			lock.Unlock()
+			return nil, fmt.Errorf("participant directory[%v] is owned by %v, take it over if it's no longer running",
+				personalPath, owner)
+		}
+		logger.Warn("take over participant directory[%v] from %v", personalPath, owner)
+	}
+
+	o := ownership{lock: lock, ownerFile: ownerFile,
+		me: OwnerInfo{Host: host, Pid: os.Getpid(), StartedAt: time.Now()}}
+	data, err := json.MarshalIndent(&o.me, "", "  ")
+	if err == nil {
+		err = WriteFileAtomic(ownerFile, data)
+	}
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return &o, nil
+}
+
+// release removes the owner file unless someone has taken it over
+func (o *ownership) release() error {
+	owner, err := readOwner(o.ownerFile)
+	if err == nil && owner != nil && owner.same(&o.me) {
+		err = os.Remove(o.ownerFile)
+	}
+	if e := o.lock.Unlock(); err == nil {
+		err = e
+	}
+	return err
+}
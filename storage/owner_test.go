@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestOwner(t *testing.T, personalPath string, owner *OwnerInfo) {
+	err := os.MkdirAll(personalPath, 0777)
+	assert.Nil(t, err)
+	data, err := json.Marshal(owner)
+	assert.Nil(t, err)
+	err = WriteFileAtomic(getOwnerFilePath(personalPath), data)
+	assert.Nil(t, err)
+}
+
+func TestParticipantOwnedByProcess(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s0 := Participant{}
+	err := s0.Init("data", "machine0")
+	assert.Nil(t, err)
+
+	s1 := Participant{}
+	err = s1.Init("data", "machine0")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is owned by")
+	err = s1.InitWithOptions("data", "machine0", &ParticipantOptions{Takeover: true})
+	assert.NotNil(t, err)
+
+	owner, err := readOwner(getOwnerFilePath(s0.me.personalPath))
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getpid(), owner.Pid)
+
+	s0.Close()
+	assert.False(t, IsFile(getOwnerFilePath(s0.me.personalPath)))
+
+	err = s1.Init("data", "machine0")
+	assert.Nil(t, err)
+	s1.Close()
+}
+
+func TestParticipantOwnedByHost(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	personalPath := getPersonalPath("data", "machine0")
+	other := OwnerInfo{Host: "another-host", Pid: 1, StartedAt: time.Now()}
+	writeTestOwner(t, personalPath, &other)
+
+	s := Participant{}
+	err := s.Init("data", "machine0")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "another-host")
+
+	err = s.InitWithOptions("data", "machine0", &ParticipantOptions{Takeover: true})
+	assert.Nil(t, err)
+	owner, err := readOwner(getOwnerFilePath(personalPath))
+	assert.Nil(t, err)
+	assert.Equal(t, os.Getpid(), owner.Pid)
+
+	// taken over by another host while we're running, its owner file is kept
+	writeTestOwner(t, personalPath, &other)
+	s.Close()
+	owner, err = readOwner(getOwnerFilePath(personalPath))
+	assert.Nil(t, err)
+	assert.True(t, owner.same(&other))
+}
+
+func TestParticipantStaleOwner(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	// left by a crashed process on this host
+	host, err := os.Hostname()
+	assert.Nil(t, err)
+	personalPath := getPersonalPath("data", "machine0")
+	writeTestOwner(t, personalPath, &OwnerInfo{Host: host, Pid: -1, StartedAt: time.Now()})
+
+	s := Participant{}
+	err = s.Init("data", "machine0")
+	assert.Nil(t, err)
+	s.Close()
+}
@@ -1,23 +1,33 @@
 package storage
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ParticipantInfo struct {
 	name string
 
-	personalPath string
-	walFile      string
-	dbFile       string
+	personalPath   string
+	walFile        string
+	dbFile         string
+	progressFile   string
+	publicKeyFile  string
+	quarantineFile string
 
 	network *NetworkInfo
 }
 
-func initParticipant(wd string, name string, network *NetworkInfo) (*ParticipantInfo, error) {
+// initParticipant creates the personal directory and the wal in format l if they don't exist,
+// l can be nil
+func initParticipant(wd string, name string, network *NetworkInfo, l LogFormat) (*ParticipantInfo, error) {
 	p := ParticipantInfo{}
 	p.Init(wd, name, network)
 
@@ -28,9 +38,9 @@ func initParticipant(wd string, name string, network *NetworkInfo) (*Participant
 		}
 	}
 
-	if !IsFile(p.walFile) {
+	if !walExists(p.walFile) {
 		w := Wal{}
-		err := w.Init(p.walFile, &BinLog{}, false)
+		err := w.Init(p.walFile, l, false)
 		if err != nil {
 			return nil, err
 		}
@@ -47,17 +57,32 @@ func (p *ParticipantInfo) Init(wd string, name string, n *NetworkInfo) {
 	personalPath := getPersonalPath(wd, name)
 	walPath := getWalFilePath(personalPath)
 	dbPath := getDBFilePath(personalPath)
+	progressPath := getProgressFilePath(personalPath)
 
 	p.name = name
 	p.personalPath = personalPath
 	p.walFile = walPath
 	p.dbFile = dbPath
+	p.progressFile = progressPath
+	p.publicKeyFile = getPublicKeyFilePath(personalPath)
+	p.quarantineFile = getQuarantineFilePath(personalPath)
 	p.network = n
 }
 
 type NetworkInfo struct {
 	wd           string
 	participants map[string]*ParticipantInfo
+	// public keys of participants, nil means all participants are trusted
+	trusted map[string]ed25519.PublicKey
+	// readonly wal of participants kept open between replays, refreshed before use
+	wals map[string]*Wal
+	// participants whose logs are not replayed until readmitted, by name,
+	// kept in quarantineFile of me
+	me             string
+	quarantined    map[string]*Quarantine
+	quarantineFile string
+	// failures which may be transient, by name, retried before quarantine
+	failures map[string]*logFailure
 }
 
 func (n *NetworkInfo) Init(wd string) error {
@@ -74,9 +99,64 @@ func (n *NetworkInfo) Init(wd string) error {
 
 	n.wd = wd
 	n.participants = participants
+	n.wals = make(map[string]*Wal)
 	return nil
 }
 
+// openWal returns the cached wal of p refreshed, it's opened if not cached,
+// l is preferred to open it
+func (n *NetworkInfo) openWal(p *ParticipantInfo, l LogFormat) (*Wal, error) {
+	if w, ok := n.wals[p.name]; ok {
+		_, err := w.Refresh()
+		if err == nil {
+			return w, nil
+		}
+		// e.g. the header is being written, open it again
+		logger.Warn("refresh wal[%v] failed[%v], reopen it", p.walFile, err)
+		n.closeWal(p.name)
+	}
+	w := Wal{}
+	err := w.InitMmap(p.walFile, l)
+	if err != nil {
+		return nil, err
+	}
+	n.wals[p.name] = &w
+	return &w, nil
+}
+
+func (n *NetworkInfo) closeWal(name string) {
+	w, ok := n.wals[name]
+	if !ok {
+		return
+	}
+	if err := w.Close(); err != nil {
+		logger.Error("close wal file[%v] failed[%v]", w.filename, err)
+	}
+	delete(n.wals, name)
+}
+
+// Close closes wal of participants
+func (n *NetworkInfo) Close() {
+	for name := range n.wals {
+		n.closeWal(name)
+	}
+}
+
+// Trust makes only participants in keys trusted, their logs must be signed by their keys
+func (n *NetworkInfo) Trust(keys map[string]ed25519.PublicKey) {
+	n.trusted = keys
+}
+
+// verifier returns the key to verify log of participant name,
+// false if it's not trusted
+func (n *NetworkInfo) verifier(name string) (ed25519.PublicKey, bool) {
+	if n.trusted == nil {
+		return nil, true
+	}
+	key, ok := n.trusted[name]
+	return key, ok
+}
+
 func (n *NetworkInfo) Add(name string) *ParticipantInfo {
 	if _, ok := n.participants[name]; !ok {
 		p := ParticipantInfo{}
@@ -101,7 +181,7 @@ func discoveryAllParticipants(wd string) ([]string, error) {
 			continue
 		}
 		sign := path.Join(path.Join(wd, e.Name()), WalFileName)
-		if IsFile(sign) {
+		if walExists(sign) {
 			list = append(list, e.Name())
 		}
 	}
@@ -152,95 +232,190 @@ func (m *LogProgressMgr) Set(machineID string, progress *LogProgress) {
 
 // Participant ...
 type Participant struct {
-	network *NetworkInfo
-	m       *LogProgressMgr
-	me      *ParticipantInfo
+	network   *NetworkInfo
+	m         *LogProgressMgr
+	persisted *LogProgressMgr
+	me        *ParticipantInfo
+	l         LogFormat
 
 	w      *WalHelper
 	ns     ReadOnlyNodeStorage
 	runner *LogRunner
+	// stamps our operations, and observes those of others
+	clock *HLC
+	// keeps other processes out of our personal directory
+	owner *ownership
 
-	// TODO
+	// when progress was published last time
 	lastSyncTime time.Time
+
+	// guards everything against the background replay started by watcher
+	mu         sync.Mutex
+	watcher    *Watcher
+	watchDone  chan struct{}
+	changed    int32 // set by watcher, replay is needed
+	lastReplay time.Time
+	lastResult *RunLogResult // of the last replay
+	// fed with operations applied by runner
+	subscriptions map[*Subscription]struct{}
+	// what's to write to db by the next checkpoint
+	cp checkpointState
+	// kept open until Close, written by checkpoints
+	db *SqliteAdapter
+}
+
+// debounce of watcher events, writes of a peer usually come in bursts
+const watchDebounce = 20 * time.Millisecond
+
+// walOpener opens wal of a participant to replay
+type walOpener func(p *ParticipantInfo) (*Wal, error)
+
+// networkWals opens wal owned by network, l is preferred to open them, it carries
+// the secret of encrypted ones
+func networkWals(network *NetworkInfo, l LogFormat) walOpener {
+	return func(p *ParticipantInfo) (*Wal, error) {
+		return network.openWal(p, l)
+	}
 }
 
-func makeRunLogInputs(network *NetworkInfo, m *LogProgressMgr) (inputs []*LogInput, retErr error) {
-	inputs = []*LogInput{}
+// wal are opened by open, logs which have been replayed to the end are not iterated.
+// logs of others which can't be opened are returned by machine, they are not iterated either
+func makeRunLogInputs(network *NetworkInfo, m *LogProgressMgr, open walOpener) ([]*LogInput, map[string]error, error) {
+	inputs := []*LogInput{}
+	failed := make(map[string]error)
 	for _, p := range network.participants {
+		key, trusted := network.verifier(p.name)
+		if !trusted {
+			logger.Warn("participant[%v] is not trusted, its log is ignored", p.name)
+			continue
+		}
 		var progress LogProgress = *m.Get(p.name)
-		w := Wal{}
-		err := w.Init(p.walFile, &BinLog{}, true)
-		if err != nil {
-			return nil, err
+		if network.isQuarantined(p.name) {
+			// kept for progress, which others may depend on
+			inputs = append(inputs, &LogInput{machineID: p.name, progress: &progress, unchanged: true})
+			continue
 		}
-		defer func(w *Wal, filename string) {
-			if retErr != nil {
-				err := w.Close()
-				if err != nil {
-					logger.Error("close wal file[%v] failed[%v]", filename, err)
-				}
+		w, err := open(p)
+		if err != nil {
+			if p.name == network.me {
+				return nil, nil, err
 			}
-		}(&w, p.walFile)
+			failed[p.name] = err
+			inputs = append(inputs, &LogInput{machineID: p.name, progress: &progress, unchanged: true})
+			continue
+		}
+		w.SetVerifier(p.name, key)
 
+		header := w.header
+		unchanged := progress.Num == header.EntryNum && progress.Gid == header.LastEntryId
 		inputs = append(inputs, &LogInput{
-			machineID: p.name, w: &w,
-			progress: &progress})
+			machineID: p.name, w: w,
+			progress: &progress, unchanged: unchanged})
 	}
-	return inputs, nil
+	return inputs, failed, nil
 }
 
-func closeRunLogInputs(inputs ...*LogInput) {
-	for _, input := range inputs {
-		if input == nil {
+func runLog(runner *LogRunner, network *NetworkInfo, m *LogProgressMgr, l LogFormat) (*RunLogResult, error) {
+	inputs, failed, err := makeRunLogInputs(network, m, networkWals(network, l))
+	if err != nil {
+		return nil, err
+	}
+	failing := make(map[string]bool)
+	for name, err := range failed {
+		// e.g. the header is being copied, retried later
+		progress := m.Get(name)
+		network.fail(&Quarantine{MachineID: name, Reason: err.Error(),
+			Segment: progress.Segment, Offset: progress.Offset, Since: time.Now()})
+		failing[name] = true
+	}
+
+	results, err := runner.Run(inputs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := results.Error(); err != nil {
+		logger.Warn("run log failed[%v]", err)
+	}
+	for _, err := range results.Failed() {
+		var corrupt *CorruptLogError
+		if !errors.As(err, &corrupt) {
 			continue
 		}
-		var filename string
-		if input.w.f != nil {
-			filename = input.w.f.Path()
+		q := &Quarantine{MachineID: corrupt.MachineID, Reason: corrupt.Err.Error(),
+			Segment: corrupt.Segment, Offset: corrupt.Offset, Since: time.Now()}
+		if corrupt.persistent() {
+			network.quarantine(q)
+		} else {
+			network.fail(q)
 		}
-		err := input.w.Close()
-		if err != nil {
-			logger.Error("close wal file[%v] failed[%v]", filename, err)
+		failing[corrupt.MachineID] = true
+	}
+	for name := range network.failures {
+		if !failing[name] {
+			delete(network.failures, name)
 		}
 	}
+	for machineID, progress := range results.status {
+		m.Set(machineID, progress)
+	}
+	return results, nil
 }
 
-func runLog(runner *LogRunner, network *NetworkInfo, m *LogProgressMgr) error {
-	inputs, err := makeRunLogInputs(network, m)
+// recoverToSqlite replays our operations which are logged but not in db yet into db
+// in one batch, e.g. those after the last checkpoint before a crash. logs of others
+// are replayed in memory as usual, they are inputs only for their progress
+func recoverToSqlite(sqlite *SqliteAdapter, network *NetworkInfo, me *ParticipantInfo, l LogFormat) error {
+	processes, err := sqlite.Processes()
 	if err != nil {
 		return err
 	}
-	defer func(inputs []*LogInput) {
-		closeRunLogInputs(inputs...)
-	}(inputs)
+	inputs := []*LogInput{}
+	progress := newLogProgress(me.name)
+	for _, process := range processes {
+		copied := *process
+		if process.MachineID == me.name {
+			progress = &copied
+			continue
+		}
+		inputs = append(inputs, &LogInput{machineID: process.MachineID, progress: &copied, unchanged: true})
+	}
+	w, err := network.openWal(me, l)
+	if err != nil {
+		return err
+	}
+	if progress.Num == w.header.EntryNum {
+		return nil
+	}
+	key, _ := network.verifier(me.name)
+	w.SetVerifier(me.name, key)
+	inputs = append(inputs, &LogInput{machineID: me.name, w: w, progress: progress})
 
+	runner := LogRunner{}
+	err = runner.Init(me.name, sqlite)
+	if err != nil {
+		return err
+	}
 	results, err := runner.Run(inputs...)
 	if err != nil {
 		return err
 	}
-	for machineID, progress := range results.status {
-		m.Set(machineID, progress)
+	if err := results.Error(); err != nil {
+		logger.Warn("recover to sqlite stopped early[%v]", err)
 	}
+	logger.Info("recover %v operations to sqlite", results.Process(me.name).Num-progress.Num)
 	return nil
 }
 
-func (p *Participant) newNodeStorageFromSqlite(dbFile string) (NodeStorage, []*LogProgress, error) {
+func (p *Participant) newNodeStorageFromSqlite(sqlite *SqliteAdapter) (NodeStorage, []*LogProgress, error) {
 	ns := NodeStorageImpl{}
 	ns.Init()
 
-	sqlite := SqliteAdapter{}
-	err := sqlite.Init(dbFile)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer sqlite.Close()
-
 	processes, err := sqlite.Processes()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	err = ns.Merge(&sqlite)
+	err = ns.Merge(sqlite)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -248,21 +423,165 @@ func (p *Participant) newNodeStorageFromSqlite(dbFile string) (NodeStorage, []*L
 	return &ns, processes, nil
 }
 
+// replayNeeded tells if logs may have something not replayed yet
+func (p *Participant) replayNeeded() bool {
+	if p.watcher == nil || atomic.LoadInt32(&p.changed) != 0 {
+		return true
+	}
+	// in case some events are missed
+	if time.Since(p.lastReplay) >= SyncInterval {
+		return true
+	}
+	// our own writes are not waited for
+	num, err := p.w.EntryNum()
+	return err != nil || p.m.Get(p.me.name).Num != num
+}
+
 func (p *Participant) runLogTillEnd() error {
-	if err := runLog(p.runner, p.network, p.m); err != nil {
+	if !p.replayNeeded() {
+		if time.Since(p.lastSyncTime) >= SyncInterval {
+			if err := p.publishProgress(); err != nil {
+				logger.Warn("publish progress failed[%v]", err)
+			}
+		}
+		return nil
+	}
+	// changes from now on will be replayed next time
+	atomic.StoreInt32(&p.changed, 0)
+	p.lastReplay = time.Now()
+
+	results, err := runLog(p.runner, p.network, p.m, p.l)
+	if err != nil {
 		return err
 	}
-	offset, err := p.w.Offset()
+	p.lastResult = results
+	p.checkpointIfNeeded()
+	num, err := p.w.EntryNum()
 	if err != nil {
 		return err
 	}
-	if p.m.Get(p.me.name).Offset != offset {
+	if p.m.Get(p.me.name).Num != num {
 		return fmt.Errorf("log progress is not yet end")
 	}
+	if time.Since(p.lastSyncTime) >= SyncInterval {
+		if err := p.publishProgress(); err != nil {
+			logger.Warn("publish progress failed[%v]", err)
+		}
+	}
+	return nil
+}
+
+// watch replays logs in background whenever watcher notices changes
+func (p *Participant) watch() {
+	defer close(p.watchDone)
+	for range p.watcher.Changes() {
+		time.Sleep(watchDebounce)
+		atomic.StoreInt32(&p.changed, 1)
+
+		p.mu.Lock()
+		if err := p.discover(); err != nil {
+			logger.Warn("discover participants failed[%v]", err)
+		}
+		if err := p.runLogTillEnd(); err != nil {
+			logger.Warn("replay in background failed[%v]", err)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// discover adds participants created after Init
+func (p *Participant) discover() error {
+	all, err := discoveryAllParticipants(p.network.wd)
+	if err != nil {
+		return err
+	}
+	for _, name := range all {
+		p.network.Add(name)
+	}
+	return nil
+}
+
+// PublishProgress writes how far we have replayed every log to our personal directory
+func (p *Participant) PublishProgress() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.publishProgress()
+}
+
+func (p *Participant) publishProgress() error {
+	progress := PublishedProgress{
+		MachineID:   p.me.name,
+		PublishedAt: time.Now(),
+		Replayed:    p.m.export(),
+		Persisted:   p.persisted.export(),
+	}
+	err := writePublishedProgress(p.me.progressFile, &progress)
+	if err != nil {
+		return err
+	}
+	p.lastSyncTime = progress.PublishedAt
 	return nil
 }
 
-func (p *Participant) Init(wd string, machineID string) (err error) {
+// PeerProgress reads progress published by all participants, ourselves included
+func (p *Participant) PeerProgress() map[string]*PublishedProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.network.PublishedProgress()
+}
+
+// PublicKeys reads public keys published by all participants, ourselves included
+func (p *Participant) PublicKeys() map[string]ed25519.PublicKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.network.PublicKeys()
+}
+
+// Trusted tells if log of participant name is replayed
+func (p *Participant) Trusted(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, trusted := p.network.verifier(name)
+	return trusted
+}
+
+// ParticipantOptions ...
+type ParticipantOptions struct {
+	// format of our wal when it's created, the format of an existing wal is detected,
+	// use the converter to change it, nil means the default format.
+	// it's also preferred to open wal of others, so an initialized CryptLog
+	// lets encrypted wal be replayed
+	LogFormat LogFormat
+	// signs our log, its public key is published in our personal directory
+	SigningKey ed25519.PrivateKey
+	// public keys of participants by name, others are ignored and logs not signed
+	// by them are rejected, ourselves are trusted by SigningKey which is required.
+	// nil means all participants are trusted
+	TrustedKeys map[string]ed25519.PublicKey
+	// when writes to our wal are fsynced, the zero value fsyncs every write
+	Durability DurabilityPolicy
+	// replay in background when changes are noticed, so that calls replay only if
+	// something has changed, which is supported on linux only, and requires the
+	// sync drive to deliver inotify events
+	Watch bool
+	// take over our personal directory though another host is recorded as its owner,
+	// which is left behind if that host crashed. a live owner on this host can't be taken over
+	Takeover bool
+	// write what's replayed to db every CheckpointInterval in background, and after a replay
+	// once CheckpointEntries operations are applied since the last checkpoint, zero disables
+	// either. db is always written by Close, what's not in db is replayed again by Init
+	CheckpointInterval time.Duration
+	CheckpointEntries  int64
+}
+
+func (p *Participant) Init(wd string, machineID string) error {
+	return p.InitWithOptions(wd, machineID, nil)
+}
+
+func (p *Participant) InitWithOptions(wd string, machineID string, opts *ParticipantOptions) (err error) {
+	if opts == nil {
+		opts = &ParticipantOptions{}
+	}
 	wd, err = ToAbs(wd)
 	if err != nil {
 		return err
@@ -280,84 +599,244 @@ func (p *Participant) Init(wd string, machineID string) (err error) {
 	if err != nil {
 		return err
 	}
+	if opts.TrustedKeys != nil {
+		if opts.SigningKey == nil {
+			return fmt.Errorf("signing key is required to trust others")
+		}
+		myKey := opts.SigningKey.Public().(ed25519.PublicKey)
+		trusted := make(map[string]ed25519.PublicKey, len(opts.TrustedKeys)+1)
+		for name, key := range opts.TrustedKeys {
+			trusted[name] = key
+		}
+		if key, ok := trusted[machineID]; ok && !key.Equal(myKey) {
+			return fmt.Errorf("trusted key of [%v] mismatches the signing key", machineID)
+		}
+		trusted[machineID] = myKey
+		network.Trust(trusted)
+	}
 
-	_, err = initParticipant(wd, machineID, &network)
+	personalPath := getPersonalPath(wd, machineID)
+	if !IsDir(personalPath) {
+		err := os.MkdirAll(personalPath, 0777)
+		if err != nil {
+			return err
+		}
+	}
+	owner, err := acquireOwnership(personalPath, opts.Takeover)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := owner.release(); e != nil {
+				logger.Error("release ownership failed[%v]", e)
+			}
+		}
+	}()
+
+	_, err = initParticipant(wd, machineID, &network, opts.LogFormat)
 	if err != nil {
 		return err
 	}
 	me := network.Add(machineID)
+	err = network.initQuarantine(me)
+	if err != nil {
+		return err
+	}
 
-	ns, offsets, err := p.newNodeStorageFromSqlite(me.dbFile)
+	sqlite := SqliteAdapter{}
+	err = sqlite.Init(me.dbFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := sqlite.Close(); e != nil {
+				logger.Error("close sqlite failed[%v]", e)
+			}
+		}
+	}()
+	err = recoverToSqlite(&sqlite, &network, me, opts.LogFormat)
+	if err != nil {
+		return err
+	}
+	ns, offsets, err := p.newNodeStorageFromSqlite(&sqlite)
 	if err != nil {
 		return err
 	}
 	m := LogProgressMgr{}
 	m.Init(offsets...)
+	persisted := LogProgressMgr{}
+	persisted.Init(offsets...)
 
 	runner := LogRunner{}
 	err = runner.Init(machineID, ns)
 	if err != nil {
 		return err
 	}
+	clock := HLC{}
+	nodes, err := ns.AllNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		clock.Update(HLCTimestamp(node.HLC))
+	}
+	runner.SetClock(&clock)
+	runner.SetObserver(p.applied)
 
 	w := WalHelper{}
-	w.Init(me.walFile, &BinLog{}, 1)
+	w.Init(me.walFile, opts.LogFormat, opts.Durability)
+	w.SetClock(&clock)
 	defer func() {
 		if err != nil {
 			w.Close()
 		}
 	}()
+	if opts.SigningKey != nil {
+		w.SetSigner(opts.SigningKey)
+		err = publishPublicKey(me.publicKeyFile, opts.SigningKey.Public().(ed25519.PublicKey))
+		if err != nil {
+			return err
+		}
+	}
 
 	p.network = &network
 	p.m = &m
+	p.persisted = &persisted
 	p.ns = ns
 	p.w = &w
 	p.me = me
+	p.l = opts.LogFormat
 	p.runner = &runner
+	p.clock = &clock
+	p.owner = owner
+	p.db = &sqlite
+	p.cp.init(opts.CheckpointInterval, opts.CheckpointEntries)
+	p.startCheckpointLoop()
+
+	if opts.Watch {
+		watcher := Watcher{}
+		if err := watcher.Init(wd); err != nil {
+			logger.Warn("watch [%v] failed[%v], replay on every call", wd, err)
+		} else {
+			p.watcher = &watcher
+			p.watchDone = make(chan struct{})
+			go p.watch()
+		}
+	}
 	return nil
 }
 
-func (p *Participant) persistToSqlite() error {
-	sqlite := SqliteAdapter{}
-	err := sqlite.Init(p.me.dbFile)
-	if err != nil {
-		return err
+// trimPoint finds the oldest segment of our log that some participant,
+// ourselves included, has not yet replayed and persisted
+func (p *Participant) trimPoint() (int64, error) {
+	if err := p.discover(); err != nil {
+		return 0, err
 	}
-	defer func() {
-		err = sqlite.Close()
-		if err != nil {
-			logger.Error("close sqlite failed[%v]", err)
+	if err := p.publishProgress(); err != nil {
+		return 0, err
+	}
+
+	published := p.network.PublishedProgress()
+	cut := int64(-1)
+	for name := range p.network.participants {
+		if _, trusted := p.network.verifier(name); !trusted {
+			continue
 		}
-	}()
+		progress, ok := published[name]
+		if !ok {
+			logger.Info("participant[%v] has not published its progress, nothing to trim", name)
+			return 0, nil
+		}
+		mine, ok := progress.Persisted[p.me.name]
+		if !ok || mine == nil {
+			logger.Info("participant[%v] has not persisted our log, nothing to trim", name)
+			return 0, nil
+		}
+		if cut < 0 || mine.Segment < cut {
+			cut = mine.Segment
+		}
+	}
+	if cut < 0 {
+		return 0, nil
+	}
+	return cut, nil
+}
 
-	processes, err := sqlite.Processes()
+// Trim deletes segments of our own log which all participants have replayed,
+// returns the number of segments deleted
+func (p *Participant) Trim() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cut, err := p.trimPoint()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	m := LogProgressMgr{}
-	m.Init(processes...)
-
-	runner := LogRunner{}
-	err = runner.Init(p.me.name, &sqlite)
+	removed, err := p.w.Trim(cut)
 	if err != nil {
-		return err
+		return removed, err
 	}
-	return runLog(&runner, p.network, &m)
+	logger.Info("trimmed %v segments before segment[%v]", removed, cut)
+	return removed, nil
 }
 
-func (p *Participant) Close() {
+// Sync makes writes so far durable, whatever the durability policy is
+func (p *Participant) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.w.Sync()
+}
+
+// Close writes what's replayed to db, an error is returned if it fails,
+// the rest is still closed
+func (p *Participant) Close() error {
+	if p.watcher != nil {
+		if err := p.watcher.Close(); err != nil {
+			logger.Error("close watcher failed[%v]", err)
+		}
+		<-p.watchDone
+		p.watcher = nil
+	}
+	p.stopCheckpointLoop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result error
 	if p.w != nil {
+		logger.Info("persist to sqlite...")
+		result = p.finalCheckpoint()
+		if result != nil {
+			logger.Error("%v", result)
+		}
 		p.w.Close()
 		p.w = nil
 	}
-	logger.Info("persist to sqlite...")
-	err := p.persistToSqlite()
+	if p.db != nil {
+		if err := p.db.Close(); err != nil {
+			logger.Error("close sqlite failed[%v]", err)
+		}
+		p.db = nil
+	}
+	err := p.publishProgress()
 	if err != nil {
-		logger.Error("persist to sqlite failed[%v]", err)
+		logger.Error("publish progress failed[%v]", err)
+	}
+	p.network.Close()
+	p.closeSubscriptions()
+	if p.owner != nil {
+		if err := p.owner.release(); err != nil {
+			logger.Error("release ownership failed[%v]", err)
+		}
+		p.owner = nil
 	}
+	return result
 }
 
+// Save logs a modification of key. an error matching ErrNotDurable means the
+// modification is logged but may be lost by a crash, it must not be saved again
 func (p *Participant) Save(key string, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err := p.runLogTillEnd(); err != nil {
 		return err
 	}
@@ -404,7 +883,10 @@ func (p *Participant) Save(key string, value string) error {
 	return err
 }
 
+// Del logs a deletion of key, errors are as those of Save
 func (p *Participant) Del(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err := p.runLogTillEnd(); err != nil {
 		return err
 	}
@@ -438,6 +920,8 @@ func (p *Participant) Del(key string) error {
 }
 
 func (p *Participant) Has(key string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err := p.runLogTillEnd(); err != nil {
 		return false, err
 	}
@@ -469,11 +953,17 @@ func filterVisible(a []*DBRecord) []*DBRecord {
 }
 
 func (p *Participant) Load(key string) (*Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err := p.runLogTillEnd(); err != nil {
 		return nil, err
 	}
 
-	leaves, err := p.ns.GetByKey(key)
+	return loadValue(p.ns, key, p.me.name)
+}
+
+func loadValue(ns ReadOnlyNodeStorage, key string, me string) (*Value, error) {
+	leaves, err := ns.GetByKey(key)
 	if err != nil {
 		return nil, err
 	}
@@ -483,7 +973,7 @@ func (p *Participant) Load(key string) (*Value, error) {
 	}
 
 	v := Value{}
-	err = v.from(leaves, p.me.name)
+	err = v.from(leaves, me)
 	if err != nil {
 		return nil, err
 	}
@@ -491,11 +981,21 @@ func (p *Participant) Load(key string) (*Value, error) {
 }
 
 func (p *Participant) All() ([]*Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.all()
+}
+
+func (p *Participant) all() ([]*Value, error) {
 	if err := p.runLogTillEnd(); err != nil {
 		return nil, err
 	}
 
-	leaves, err := p.ns.AllNodes()
+	return allValues(p.ns, p.me.name)
+}
+
+func allValues(ns ReadOnlyNodeStorage, me string) ([]*Value, error) {
+	leaves, err := ns.AllNodes()
 	if err != nil {
 		return nil, err
 	}
@@ -512,7 +1012,7 @@ func (p *Participant) All() ([]*Value, error) {
 	results := make([]*Value, 0)
 	for _, l := range m {
 		v := Value{}
-		err := v.from(l, p.me.name)
+		err := v.from(l, me)
 		if err != nil {
 			return nil, err
 		}
@@ -521,6 +1021,62 @@ func (p *Participant) All() ([]*Value, error) {
 	return results, nil
 }
 
+// Change is the latest change of a key
+type Change struct {
+	Key       string
+	Timestamp HLCTimestamp
+	// nil if the key is deleted
+	Value *Value
+}
+
+// ChangedSince returns keys changed after ts, ordered by when they are changed last.
+// changes logged before timestamps were introduced are never returned
+func (p *Participant) ChangedSince(ts HLCTimestamp) ([]*Change, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.runLogTillEnd(); err != nil {
+		return nil, err
+	}
+
+	leaves, err := p.ns.AllNodes()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]*DBRecord)
+	for _, l := range leaves {
+		m[l.Key] = append(m[l.Key], l)
+	}
+
+	results := []*Change{}
+	for key, l := range m {
+		last := HLCTimestamp(0)
+		for _, leaf := range l {
+			if HLCTimestamp(leaf.HLC) > last {
+				last = HLCTimestamp(leaf.HLC)
+			}
+		}
+		if last <= ts {
+			continue
+		}
+		change := Change{Key: key, Timestamp: last}
+		if visible := filterVisible(l); len(visible) > 0 {
+			v := Value{}
+			if err := v.from(visible, p.me.name); err != nil {
+				return nil, err
+			}
+			change.Value = &v
+		}
+		results = append(results, &change)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Timestamp < results[j].Timestamp })
+	return results, nil
+}
+
+// LastTimestamp is the latest timestamp we have made or seen
+func (p *Participant) LastTimestamp() HLCTimestamp {
+	return p.clock.Last()
+}
+
 func (p *Participant) makeDiscardOperation(gid string) (*LogOperation, error) {
 	record, err := p.ns.GetByGid(gid)
 	if err != nil {
@@ -541,6 +1097,8 @@ func (p *Participant) makeDiscardOperation(gid string) (*LogOperation, error) {
 }
 
 func (p *Participant) Accept(v *Value, seq int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	if err := p.runLogTillEnd(); err != nil {
 		return err
 	}
@@ -575,7 +1133,9 @@ func (p *Participant) Accept(v *Value, seq int) error {
 }
 
 func (p *Participant) AllConflicts() ([]*Value, error) {
-	all, err := p.All()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all, err := p.all()
 	if err != nil {
 		return nil, err
 	}
@@ -244,7 +244,8 @@ func TestRandomOperationsAndRecovery(t *testing.T) {
 	expected := map[string]string{}
 	for i := 0; i <= totalNumOfOperations; i++ {
 		r := rand.Intn(10)
-		if r <= 4 { // add a new key--50%
+		// nothing to modify or delete before the first key is added
+		if r <= 4 || key == 0 { // add a new key--50%
 			k := strconv.FormatInt(int64(key), 10)
 			v := strconv.FormatInt(rand.Int63(), 10)
 			expected[k] = v
@@ -287,3 +288,262 @@ func TestRandomOperationsAndRecovery(t *testing.T) {
 	fmt.Println(getFileSize(dbFile))
 	fmt.Println(getFileSize(walFile))
 }
+
+func TestParticipantTrim(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	const testKey = "testKey"
+	const testValue = "testValue"
+	s0 := Participant{}
+	err := s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	s0.w.SetSegmentLimit(0, 1)
+	for i := 0; i < 3; i++ {
+		err = s0.Save(testKey+fmt.Sprint(i), testValue+fmt.Sprint(i))
+		assert.Nil(t, err)
+	}
+
+	// nobody has persisted its progress yet
+	removed, err := s0.Trim()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, removed)
+	s0.Close()
+
+	s1 := Participant{}
+	err = s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	records, err := s1.All()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(records))
+	s1.Close()
+
+	s0 = Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	removed, err = s0.Trim()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	err = s0.Save(testKey+"3", testValue+"3")
+	assert.Nil(t, err)
+	s0.Close()
+
+	s1 = Participant{}
+	err = s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	defer s1.Close()
+	records, err = s1.All()
+	assert.Nil(t, err)
+	expected := [][2]string{{testKey + "0", testValue + "0"},
+		{testKey + "1", testValue + "1"},
+		{testKey + "2", testValue + "2"},
+		{testKey + "3", testValue + "3"}}
+	assert.ElementsMatch(t, expected, valuesToArray(records))
+}
+
+func TestPublishProgress(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s0 := Participant{}
+	err := s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	err = s0.Save("testKey", "testValue")
+	assert.Nil(t, err)
+	s0.Close()
+
+	s1 := Participant{}
+	err = s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	defer s1.Close()
+	_, err = s1.All()
+	assert.Nil(t, err)
+	err = s1.PublishProgress()
+	assert.Nil(t, err)
+
+	all := s1.PeerProgress()
+	assert.Equal(t, 2, len(all))
+	assert.Equal(t, int64(1), all["machine0"].Persisted["machine0"].Num)
+	progress := all["machine1"].Replayed["machine0"]
+	assert.NotNil(t, progress)
+	assert.Equal(t, int64(1), progress.Num)
+	assert.False(t, progress.AppliedAt.IsZero())
+	_, ok := all["machine1"].Persisted["machine0"]
+	assert.False(t, ok)
+}
+
+func TestParticipantMixedLogFormats(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	formats := []LogFormat{&BinLog{}, &JsonLog{}, &FlateLog{}}
+	for i, l := range formats {
+		s := Participant{}
+		err := s.InitWithOptions("data", fmt.Sprint("machine", i), &ParticipantOptions{LogFormat: l})
+		assert.Nil(t, err)
+		format, err := s.w.Format()
+		assert.Nil(t, err)
+		assert.Equal(t, l.ID(), format.ID())
+		err = s.Save(fmt.Sprint("testKey", i), fmt.Sprint("testValue", i))
+		assert.Nil(t, err)
+		s.Close()
+	}
+
+	s := Participant{}
+	err := s.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s.Close()
+	records, err := s.All()
+	assert.Nil(t, err)
+	expected := [][2]string{{"testKey0", "testValue0"},
+		{"testKey1", "testValue1"},
+		{"testKey2", "testValue2"}}
+	assert.ElementsMatch(t, expected, valuesToArray(records))
+}
+
+func TestParticipantChangedSince(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	// written before timestamps were introduced
+	err := os.MkdirAll(getPersonalPath("data", "machine1"), 0777)
+	assert.Nil(t, err)
+	legacy := Wal{}
+	err = legacy.Init(getWalFilePath(getPersonalPath("data", "machine1")), nil, false)
+	assert.Nil(t, err)
+	err = legacy.AppendRaw(&LogOperation{Op: int32(Op_Modify), Key: "oldKey", Value: "oldValue",
+		Gid: "legacy-gid", Num: 1, MachineId: "machine1", Changes: map[string]int32{"machine1": 1}})
+	assert.Nil(t, err)
+	legacy.Close()
+
+	s := Participant{}
+	err = s.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s.Close()
+	err = s.Save("testKey0", "testValue0")
+	assert.Nil(t, err)
+	err = s.Save("testKey1", "testValue1")
+	assert.Nil(t, err)
+	since := s.LastTimestamp()
+	assert.False(t, since.IsZero())
+
+	err = s.Save("testKey2", "testValue2")
+	assert.Nil(t, err)
+	err = s.Del("testKey0")
+	assert.Nil(t, err)
+
+	changes, err := s.ChangedSince(since)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, "testKey2", changes[0].Key)
+	assert.Equal(t, "testValue2", changes[0].Value.Main().value)
+	assert.Equal(t, changes[0].Timestamp, changes[0].Value.Main().Timestamp())
+	assert.Equal(t, "testKey0", changes[1].Key)
+	assert.Nil(t, changes[1].Value)
+	assert.True(t, changes[1].Timestamp > changes[0].Timestamp)
+
+	v, err := s.Load("oldKey")
+	assert.Nil(t, err)
+	assert.True(t, v.Main().Timestamp().IsZero())
+	changes, err = s.ChangedSince(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(changes))
+}
+
+func TestParticipantSkipUnchangedLogs(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s1 := Participant{}
+	err := s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	err = s1.Save("testKey1", "testValue1")
+	assert.Nil(t, err)
+
+	s0 := Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s0.Close()
+	_, err = s0.All()
+	assert.Nil(t, err)
+
+	unchanged := func() map[string]bool {
+		inputs, _, err := makeRunLogInputs(s0.network, s0.m, networkWals(s0.network, nil))
+		assert.Nil(t, err)
+		results := make(map[string]bool)
+		for _, input := range inputs {
+			results[input.machineID] = input.unchanged
+		}
+		return results
+	}
+	assert.Equal(t, map[string]bool{"machine0": true, "machine1": true}, unchanged())
+	cached := s0.network.wals["machine1"]
+
+	err = s1.Save("testKey2", "testValue2")
+	assert.Nil(t, err)
+	s1.Close()
+	assert.Equal(t, map[string]bool{"machine0": true, "machine1": false}, unchanged())
+	assert.Same(t, cached, s0.network.wals["machine1"])
+
+	records, err := s0.All()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, map[string]bool{"machine0": true, "machine1": true}, unchanged())
+}
+
+func TestParticipantBlockedStatus(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	// machine1 modified a key of machine2, whose log is not synced yet
+	err := os.MkdirAll(getPersonalPath("data", "machine1"), 0777)
+	assert.Nil(t, err)
+	w := Wal{}
+	err = w.Init(getWalFilePath(getPersonalPath("data", "machine1")), nil, false)
+	assert.Nil(t, err)
+	_, _, err = w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "testValue1",
+		PrevGid: "parent-gid", PrevNum: 1, PrevMachineId: "machine2", MachineId: "machine1",
+		Changes: map[string]int32{"machine1": 1}})
+	assert.Nil(t, err)
+	w.Close()
+
+	s := Participant{}
+	err = s.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s.Close()
+
+	status, err := s.Status()
+	assert.Nil(t, err)
+	blocked := status["machine1"].Blocked
+	assert.NotNil(t, blocked)
+	assert.Equal(t, "machine2", blocked.PrevMachineID)
+	assert.Equal(t, int64(1), blocked.PrevNum)
+	assert.Equal(t, "parent-gid", blocked.PrevGid)
+	assert.Equal(t, "testValue1", blocked.Op.Value)
+	assert.Equal(t, int64(1), status["machine1"].Behind())
+	assert.Nil(t, status["machine0"].Blocked)
+
+	// still blocked at the same operation
+	status, err = s.Status()
+	assert.Nil(t, err)
+	assert.Equal(t, blocked.Since, status["machine1"].Blocked.Since)
+
+	err = os.MkdirAll(getPersonalPath("data", "machine2"), 0777)
+	assert.Nil(t, err)
+	w = Wal{}
+	err = w.Init(getWalFilePath(getPersonalPath("data", "machine2")), nil, false)
+	assert.Nil(t, err)
+	err = w.AppendRaw(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "testValue0",
+		Gid: "parent-gid", Num: 1, MachineId: "machine2", Changes: map[string]int32{"machine2": 1}})
+	assert.Nil(t, err)
+	w.Close()
+
+	status, err = s.Status()
+	assert.Nil(t, err)
+	assert.Nil(t, status["machine1"].Blocked)
+	assert.Equal(t, int64(0), status["machine1"].Behind())
+	assert.Equal(t, int64(1), status["machine2"].Num)
+	v, err := s.Load("testKey")
+	assert.Nil(t, err)
+	assert.Equal(t, "testValue1", v.Main().value)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+const ProgressFileName = "progress.json"
+
+// PeerProgress is how far the log of a participant has been replayed
+type PeerProgress struct {
+	Gid     string `json:"gid"`
+	Num     int64  `json:"num"`
+	Segment int64  `json:"segment"`
+	Offset  int64  `json:"offset"`
+	// wall-clock time the last entry was applied
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// PublishedProgress is published by every participant in its personal directory,
+// so that others know who has seen what
+type PublishedProgress struct {
+	MachineID   string    `json:"machine_id"`
+	PublishedAt time.Time `json:"published_at"`
+	// replayed into memory
+	Replayed map[string]*PeerProgress `json:"replayed"`
+	// persisted to db, which will never be replayed again,
+	// logs before it can be trimmed safely
+	Persisted map[string]*PeerProgress `json:"persisted"`
+}
+
+func getProgressFilePath(personalPath string) string {
+	progressFile := path.Join(personalPath, ProgressFileName)
+	return progressFile
+}
+
+func (m *LogProgressMgr) export() map[string]*PeerProgress {
+	results := make(map[string]*PeerProgress)
+	for machineID, progress := range m.m {
+		if progress == nil {
+			continue
+		}
+		appliedAt := progress.AppliedAt
+		if appliedAt.IsZero() {
+			appliedAt = progress.UpdatedAt
+		}
+		results[machineID] = &PeerProgress{
+			Gid:       progress.Gid,
+			Num:       progress.Num,
+			Segment:   progress.Segment,
+			Offset:    progress.Offset,
+			AppliedAt: appliedAt,
+		}
+	}
+	return results
+}
+
+func writePublishedProgress(filename string, progress *PublishedProgress) error {
+	data, err := json.MarshalIndent(progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, data)
+}
+
+// readPublishedProgress returns nil if nothing has been published
+func readPublishedProgress(filename string) (*PublishedProgress, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	progress := PublishedProgress{}
+	err = json.Unmarshal(data, &progress)
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// PublishedProgress reads progress published by all participants,
+// those having published nothing or something unreadable are absent
+func (n *NetworkInfo) PublishedProgress() map[string]*PublishedProgress {
+	results := make(map[string]*PublishedProgress)
+	for name, p := range n.participants {
+		progress, err := readPublishedProgress(p.progressFile)
+		if err != nil {
+			logger.Warn("read progress of participant[%v] failed[%v]", name, err)
+			continue
+		}
+		if progress == nil {
+			continue
+		}
+		results[name] = progress
+	}
+	return results
+}
@@ -4,6 +4,7 @@
 package storage
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
 	io "io"
@@ -58,6 +59,14 @@ type FileHeader struct {
 	FileEnd              int64    `protobuf:"varint,2,opt,name=file_end,json=fileEnd,proto3" json:"file_end,omitempty"`
 	LastEntryId          string   `protobuf:"bytes,3,opt,name=last_entry_id,json=lastEntryId,proto3" json:"last_entry_id,omitempty"`
 	EntryNum             int64    `protobuf:"varint,4,opt,name=entry_num,json=entryNum,proto3" json:"entry_num,omitempty"`
+	Segment              int64    `protobuf:"varint,5,opt,name=segment,proto3" json:"segment,omitempty"`
+	StartNum             int64    `protobuf:"varint,6,opt,name=start_num,json=startNum,proto3" json:"start_num,omitempty"`
+	Magic                uint32   `protobuf:"fixed32,7,opt,name=magic,proto3" json:"magic,omitempty"`
+	Format               int32    `protobuf:"varint,8,opt,name=format,proto3" json:"format,omitempty"`
+	Version              int32    `protobuf:"varint,9,opt,name=version,proto3" json:"version,omitempty"`
+	Checksum             uint32   `protobuf:"fixed32,10,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Salt                 []byte   `protobuf:"bytes,11,opt,name=salt,proto3" json:"salt,omitempty"`
+	Mac                  []byte   `protobuf:"bytes,12,opt,name=mac,proto3" json:"mac,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -124,6 +133,62 @@ func (m *FileHeader) GetEntryNum() int64 {
 	return 0
 }
 
+func (m *FileHeader) GetSegment() int64 {
+	if m != nil {
+		return m.Segment
+	}
+	return 0
+}
+
+func (m *FileHeader) GetStartNum() int64 {
+	if m != nil {
+		return m.StartNum
+	}
+	return 0
+}
+
+func (m *FileHeader) GetMagic() uint32 {
+	if m != nil {
+		return m.Magic
+	}
+	return 0
+}
+
+func (m *FileHeader) GetFormat() int32 {
+	if m != nil {
+		return m.Format
+	}
+	return 0
+}
+
+func (m *FileHeader) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *FileHeader) GetChecksum() uint32 {
+	if m != nil {
+		return m.Checksum
+	}
+	return 0
+}
+
+func (m *FileHeader) GetSalt() []byte {
+	if m != nil {
+		return m.Salt
+	}
+	return nil
+}
+
+func (m *FileHeader) GetMac() []byte {
+	if m != nil {
+		return m.Mac
+	}
+	return nil
+}
+
 type LogOperation struct {
 	Op                   int32            `protobuf:"varint,1,opt,name=op,proto3" json:"op,omitempty"`
 	Key                  string           `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
@@ -137,6 +202,7 @@ type LogOperation struct {
 	Changes              map[string]int32 `protobuf:"bytes,10,rep,name=changes,proto3" json:"changes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
 	Num                  int64            `protobuf:"varint,11,opt,name=num,proto3" json:"num,omitempty"`
 	PrevNum              int64            `protobuf:"varint,12,opt,name=prev_num,json=prevNum,proto3" json:"prev_num,omitempty"`
+	Hlc                  int64            `protobuf:"varint,13,opt,name=hlc,proto3" json:"hlc,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
 	XXX_unrecognized     []byte           `json:"-"`
 	XXX_sizecache        int32            `json:"-"`
@@ -259,8 +325,16 @@ func (m *LogOperation) GetPrevNum() int64 {
 	return 0
 }
 
+func (m *LogOperation) GetHlc() int64 {
+	if m != nil {
+		return m.Hlc
+	}
+	return 0
+}
+
 type LogEntry struct {
 	Ops                  []*LogOperation `protobuf:"bytes,1,rep,name=ops,proto3" json:"ops,omitempty"`
+	Signature            []byte          `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
 	XXX_unrecognized     []byte          `json:"-"`
 	XXX_sizecache        int32           `json:"-"`
@@ -306,6 +380,13 @@ func (m *LogEntry) GetOps() []*LogOperation {
 	return nil
 }
 
+func (m *LogEntry) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("Op", Op_name, Op_value)
 	proto.RegisterType((*FileHeader)(nil), "FileHeader")
@@ -317,35 +398,42 @@ func init() {
 func init() { proto.RegisterFile("proto.proto", fileDescriptor_2fcc84b9998d60d8) }
 
 var fileDescriptor_2fcc84b9998d60d8 = []byte{
-	// 438 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x52, 0xcd, 0x6e, 0xd3, 0x40,
-	0x10, 0xee, 0xda, 0x49, 0x6c, 0x8f, 0x13, 0xb0, 0x56, 0x1c, 0xdc, 0x22, 0x42, 0x94, 0x03, 0x8a,
-	0x40, 0x8a, 0x10, 0x70, 0x40, 0x3d, 0x42, 0x0b, 0x54, 0x6a, 0x53, 0xc9, 0x07, 0x0e, 0x5c, 0x22,
-	0x93, 0x9d, 0xba, 0x2b, 0x9c, 0x5d, 0xe3, 0x9f, 0x8a, 0xbc, 0x02, 0x4f, 0xc0, 0x23, 0x71, 0xe4,
-	0x11, 0x50, 0x78, 0x11, 0x34, 0xb3, 0x4d, 0x15, 0x24, 0x2e, 0xd6, 0xcc, 0x37, 0xf3, 0x7d, 0x33,
-	0xf3, 0x79, 0x21, 0xae, 0x6a, 0xdb, 0xda, 0x39, 0x7f, 0xa7, 0xdf, 0x00, 0xde, 0xe9, 0x12, 0x3f,
-	0x60, 0xae, 0xb0, 0x96, 0xf7, 0xc0, 0xd3, 0x2a, 0x15, 0x13, 0x31, 0x8b, 0x32, 0x4f, 0x2b, 0x79,
-	0x08, 0xe1, 0x95, 0x2e, 0x71, 0x89, 0x46, 0xa5, 0xde, 0x44, 0xcc, 0xfc, 0x2c, 0xa0, 0xfc, 0xd4,
-	0x28, 0x39, 0x85, 0x51, 0x99, 0x37, 0xed, 0x12, 0x4d, 0x5b, 0x6f, 0x96, 0x5a, 0xa5, 0x3e, 0xb3,
-	0x62, 0x02, 0x4f, 0x09, 0x3b, 0x53, 0xf2, 0x21, 0x44, 0xae, 0x6c, 0xba, 0x75, 0xda, 0x63, 0x7e,
-	0xc8, 0xc0, 0xa2, 0x5b, 0x4f, 0xbf, 0xfb, 0x30, 0x3c, 0xb7, 0xc5, 0x65, 0x85, 0x75, 0xde, 0x6a,
-	0x6b, 0x68, 0xb8, 0xad, 0x78, 0x78, 0x3f, 0xf3, 0x6c, 0x25, 0x13, 0xf0, 0xbf, 0xe0, 0x86, 0xe7,
-	0x46, 0x19, 0x85, 0xf2, 0x01, 0xf4, 0x6f, 0xf2, 0xb2, 0xc3, 0xdb, 0x59, 0x2e, 0xa1, 0xbe, 0x42,
-	0x2b, 0xd6, 0x8f, 0x32, 0x0a, 0x69, 0xed, 0xaa, 0xc6, 0x9b, 0x25, 0xc1, 0x7d, 0x86, 0x03, 0xca,
-	0xdf, 0x6b, 0x25, 0x1f, 0x01, 0x70, 0xc9, 0xe9, 0x0c, 0xb8, 0x18, 0x11, 0xf2, 0x71, 0xa7, 0xd5,
-	0xe0, 0xd7, 0x34, 0x98, 0x88, 0x59, 0x2f, 0xa3, 0x90, 0x08, 0xeb, 0x7c, 0x75, 0xad, 0x0d, 0xd2,
-	0x91, 0xa1, 0x23, 0xdc, 0x22, 0x67, 0x4a, 0x3e, 0x81, 0xfb, 0xac, 0xb7, 0xd7, 0x13, 0x71, 0xcf,
-	0x88, 0xe0, 0x8b, 0xbb, 0xbe, 0x57, 0x10, 0xac, 0xae, 0x73, 0x53, 0x60, 0x93, 0xc2, 0xc4, 0x9f,
-	0xc5, 0x2f, 0x8e, 0xe6, 0xfb, 0xc7, 0xcf, 0xdf, 0xba, 0x22, 0x3b, 0x97, 0xed, 0x5a, 0x69, 0x1d,
-	0xb2, 0x2e, 0x66, 0xeb, 0x28, 0xbc, 0x3b, 0x8d, 0xe0, 0xa1, 0xfb, 0x23, 0x94, 0x2f, 0xba, 0xf5,
-	0xd1, 0x31, 0x0c, 0xf7, 0x55, 0x76, 0xfe, 0x89, 0xff, 0xf8, 0xe7, 0xb1, 0xc9, 0x2e, 0x39, 0xf6,
-	0x5e, 0x8b, 0xe9, 0x33, 0x08, 0xcf, 0x6d, 0xe1, 0x78, 0x8f, 0xc1, 0xb7, 0x55, 0x93, 0x0a, 0x5e,
-	0x73, 0xf4, 0xcf, 0x9a, 0x19, 0x55, 0x9e, 0x3e, 0x07, 0xef, 0xb2, 0x92, 0x21, 0xf4, 0x16, 0xd6,
-	0x60, 0x72, 0x20, 0x01, 0x06, 0x17, 0x56, 0xe9, 0xab, 0x4d, 0x22, 0x64, 0x00, 0xfe, 0x09, 0x96,
-	0x89, 0x27, 0x63, 0x08, 0x4e, 0x74, 0xb3, 0xca, 0x6b, 0x95, 0xf8, 0x6f, 0x0e, 0x7f, 0x6e, 0xc7,
-	0xe2, 0xd7, 0x76, 0x2c, 0x7e, 0x6f, 0xc7, 0xe2, 0xc7, 0x9f, 0xf1, 0xc1, 0xa7, 0xa0, 0x69, 0x6d,
-	0x9d, 0x17, 0xf8, 0x79, 0xc0, 0xef, 0xf0, 0xe5, 0xdf, 0x00, 0x00, 0x00, 0xff, 0xff, 0xe6, 0xa6,
-	0xa7, 0x61, 0x96, 0x02, 0x00, 0x00,
+	// 554 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0xcd, 0x6e, 0xd3, 0x40,
+	0x10, 0xee, 0xda, 0x49, 0x6c, 0x4f, 0x92, 0x12, 0xad, 0x10, 0xda, 0x16, 0x08, 0x51, 0x0e, 0xc8,
+	0xe2, 0x50, 0x21, 0xe0, 0x80, 0x7a, 0x84, 0x16, 0xa8, 0xd4, 0x1f, 0xc9, 0x07, 0x0e, 0x5c, 0xa2,
+	0xc5, 0xbb, 0x75, 0x56, 0xb5, 0xbd, 0xc6, 0xeb, 0x54, 0xea, 0x9b, 0x70, 0xe0, 0x45, 0x78, 0x03,
+	0x8e, 0x3c, 0x02, 0x2a, 0x2f, 0x82, 0x66, 0x36, 0x29, 0x41, 0xe2, 0x12, 0xcd, 0xf7, 0xcd, 0x7c,
+	0x5f, 0x66, 0xbf, 0x91, 0x61, 0xd8, 0xb4, 0xb6, 0xb3, 0x07, 0xf4, 0x3b, 0xff, 0x1e, 0x00, 0xbc,
+	0x33, 0xa5, 0xfe, 0xa0, 0xa5, 0xd2, 0x2d, 0xdf, 0x85, 0xc0, 0x28, 0xc1, 0x66, 0x2c, 0x4d, 0xb2,
+	0xc0, 0x28, 0xbe, 0x07, 0xf1, 0xa5, 0x29, 0xf5, 0x42, 0xd7, 0x4a, 0x04, 0x33, 0x96, 0x86, 0x59,
+	0x84, 0xf8, 0xb8, 0x56, 0x7c, 0x0e, 0xe3, 0x52, 0xba, 0x6e, 0xa1, 0xeb, 0xae, 0xbd, 0x59, 0x18,
+	0x25, 0x42, 0x52, 0x0d, 0x91, 0x3c, 0x46, 0xee, 0x44, 0xf1, 0x87, 0x90, 0xf8, 0x76, 0xbd, 0xaa,
+	0x44, 0x8f, 0xf4, 0x31, 0x11, 0xe7, 0xab, 0x8a, 0x0b, 0x88, 0x9c, 0x2e, 0x2a, 0x5d, 0x77, 0xa2,
+	0xef, 0xad, 0xd7, 0x10, 0x65, 0xae, 0x93, 0x6d, 0x47, 0xb2, 0x81, 0x97, 0x11, 0x81, 0xb2, 0xfb,
+	0xd0, 0xaf, 0x64, 0x61, 0x72, 0x11, 0xcd, 0x58, 0x1a, 0x65, 0x1e, 0xf0, 0x07, 0x30, 0xb8, 0xb4,
+	0x6d, 0x25, 0x3b, 0x11, 0xcf, 0x58, 0xda, 0xcf, 0xd6, 0x08, 0xff, 0xe4, 0x5a, 0xb7, 0xce, 0xd8,
+	0x5a, 0x24, 0xd4, 0xd8, 0x40, 0xbe, 0x0f, 0x71, 0xbe, 0xd4, 0xf9, 0x95, 0x5b, 0x55, 0x02, 0xc8,
+	0xea, 0x0e, 0x73, 0x0e, 0x3d, 0x27, 0xcb, 0x4e, 0x0c, 0x67, 0x2c, 0x1d, 0x65, 0x54, 0xf3, 0x09,
+	0x84, 0x95, 0xcc, 0xc5, 0x88, 0x28, 0x2c, 0xe7, 0xdf, 0x42, 0x18, 0x9d, 0xda, 0xe2, 0xa2, 0xd1,
+	0xad, 0xec, 0xd0, 0x72, 0x17, 0x02, 0xdb, 0x50, 0x7a, 0xfd, 0x2c, 0xb0, 0x0d, 0x4a, 0xae, 0xf4,
+	0x0d, 0x05, 0x97, 0x64, 0x58, 0xe2, 0xf2, 0xd7, 0xb2, 0x5c, 0xe9, 0x75, 0x58, 0x1e, 0xe0, 0x5c,
+	0x61, 0x14, 0x05, 0x94, 0x64, 0x61, 0xe1, 0x73, 0x6f, 0x5a, 0x7d, 0xbd, 0x40, 0xba, 0x4f, 0x74,
+	0x84, 0xf8, 0xbd, 0x51, 0xfc, 0x31, 0x00, 0xb5, 0xbc, 0xcf, 0x80, 0x9a, 0x09, 0x32, 0x1f, 0x37,
+	0x5e, 0x4e, 0x7f, 0xa1, 0x70, 0x7a, 0x19, 0x96, 0x28, 0xa8, 0x64, 0xbe, 0x34, 0xb5, 0xc6, 0x2b,
+	0xc5, 0x5e, 0xb0, 0x66, 0x4e, 0x14, 0x7f, 0x0a, 0xf7, 0xc8, 0x6f, 0x6b, 0x26, 0xa1, 0x99, 0x31,
+	0xd2, 0x67, 0x77, 0x73, 0xaf, 0x20, 0xca, 0x97, 0xb2, 0x2e, 0xb4, 0x13, 0x30, 0x0b, 0xd3, 0xe1,
+	0x8b, 0xfd, 0x83, 0xed, 0xc7, 0x1f, 0xbc, 0xf5, 0x4d, 0x3a, 0x7d, 0xb6, 0x19, 0xc5, 0x75, 0xf0,
+	0x88, 0x43, 0x3a, 0x22, 0x96, 0x77, 0x4f, 0x43, 0x7a, 0xe4, 0xef, 0x8e, 0x18, 0x4f, 0x3b, 0x81,
+	0x70, 0x59, 0xe6, 0x62, 0xec, 0x87, 0x97, 0x65, 0xbe, 0x7f, 0x08, 0xa3, 0x6d, 0xdf, 0x4d, 0xa2,
+	0xec, 0x3f, 0x89, 0x06, 0x14, 0xbb, 0x07, 0x87, 0xc1, 0x6b, 0x36, 0x3f, 0x81, 0xf8, 0xd4, 0x16,
+	0x5e, 0xf7, 0x04, 0x42, 0xdb, 0x38, 0xc1, 0x68, 0xf1, 0xf1, 0x3f, 0x8b, 0x67, 0xd8, 0xe1, 0x8f,
+	0x20, 0x71, 0xa6, 0xa8, 0x65, 0xb7, 0x6a, 0xbd, 0xd5, 0x28, 0xfb, 0x4b, 0x3c, 0x7b, 0x0e, 0xc1,
+	0x45, 0xc3, 0x63, 0xe8, 0x9d, 0xdb, 0x5a, 0x4f, 0x76, 0x38, 0xc0, 0xe0, 0xcc, 0x2a, 0x73, 0x79,
+	0x33, 0x61, 0x3c, 0x82, 0xf0, 0x48, 0x97, 0x93, 0x80, 0x0f, 0x21, 0x3a, 0x32, 0x2e, 0x97, 0xad,
+	0x9a, 0x84, 0x6f, 0xf6, 0x7e, 0xdc, 0x4e, 0xd9, 0xcf, 0xdb, 0x29, 0xfb, 0x75, 0x3b, 0x65, 0x5f,
+	0x7f, 0x4f, 0x77, 0x3e, 0x45, 0xae, 0xb3, 0xad, 0x2c, 0xf4, 0xe7, 0x01, 0x7d, 0x79, 0x2f, 0xff,
+	0x0c, 0x00, 0xb5, 0xda, 0xc5, 0xc6, 0x88, 0x03, 0x00, 0x00,
 }
 
 func (m *FileHeader) Marshal() (dAtA []byte, err error) {
@@ -372,6 +460,52 @@ func (m *FileHeader) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.Mac) > 0 {
+		i -= len(m.Mac)
+		copy(dAtA[i:], m.Mac)
+		i = encodeVarintProto(dAtA, i, uint64(len(m.Mac)))
+		i--
+		dAtA[i] = 0x62
+	}
+	if len(m.Salt) > 0 {
+		i -= len(m.Salt)
+		copy(dAtA[i:], m.Salt)
+		i = encodeVarintProto(dAtA, i, uint64(len(m.Salt)))
+		i--
+		dAtA[i] = 0x5a
+	}
+	if m.Checksum != 0 {
+		i -= 4
+		encoding_binary.LittleEndian.PutUint32(dAtA[i:], uint32(m.Checksum))
+		i--
+		dAtA[i] = 0x55
+	}
+	if m.Version != 0 {
+		i = encodeVarintProto(dAtA, i, uint64(m.Version))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.Format != 0 {
+		i = encodeVarintProto(dAtA, i, uint64(m.Format))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.Magic != 0 {
+		i -= 4
+		encoding_binary.LittleEndian.PutUint32(dAtA[i:], uint32(m.Magic))
+		i--
+		dAtA[i] = 0x3d
+	}
+	if m.StartNum != 0 {
+		i = encodeVarintProto(dAtA, i, uint64(m.StartNum))
+		i--
+		dAtA[i] = 0x30
+	}
+	if m.Segment != 0 {
+		i = encodeVarintProto(dAtA, i, uint64(m.Segment))
+		i--
+		dAtA[i] = 0x28
+	}
 	if m.EntryNum != 0 {
 		i = encodeVarintProto(dAtA, i, uint64(m.EntryNum))
 		i--
@@ -423,6 +557,11 @@ func (m *LogOperation) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.Hlc != 0 {
+		i = encodeVarintProto(dAtA, i, uint64(m.Hlc))
+		i--
+		dAtA[i] = 0x68
+	}
 	if m.PrevNum != 0 {
 		i = encodeVarintProto(dAtA, i, uint64(m.PrevNum))
 		i--
@@ -536,6 +675,13 @@ func (m *LogEntry) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.Signature) > 0 {
+		i -= len(m.Signature)
+		copy(dAtA[i:], m.Signature)
+		i = encodeVarintProto(dAtA, i, uint64(len(m.Signature)))
+		i--
+		dAtA[i] = 0x12
+	}
 	if len(m.Ops) > 0 {
 		for iNdEx := len(m.Ops) - 1; iNdEx >= 0; iNdEx-- {
 			{
@@ -584,6 +730,32 @@ func (m *FileHeader) Size() (n int) {
 	if m.EntryNum != 0 {
 		n += 1 + sovProto(uint64(m.EntryNum))
 	}
+	if m.Segment != 0 {
+		n += 1 + sovProto(uint64(m.Segment))
+	}
+	if m.StartNum != 0 {
+		n += 1 + sovProto(uint64(m.StartNum))
+	}
+	if m.Magic != 0 {
+		n += 5
+	}
+	if m.Format != 0 {
+		n += 1 + sovProto(uint64(m.Format))
+	}
+	if m.Version != 0 {
+		n += 1 + sovProto(uint64(m.Version))
+	}
+	if m.Checksum != 0 {
+		n += 5
+	}
+	l = len(m.Salt)
+	if l > 0 {
+		n += 1 + l + sovProto(uint64(l))
+	}
+	l = len(m.Mac)
+	if l > 0 {
+		n += 1 + l + sovProto(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -644,6 +816,9 @@ func (m *LogOperation) Size() (n int) {
 	if m.PrevNum != 0 {
 		n += 1 + sovProto(uint64(m.PrevNum))
 	}
+	if m.Hlc != 0 {
+		n += 1 + sovProto(uint64(m.Hlc))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -662,6 +837,10 @@ func (m *LogEntry) Size() (n int) {
 			n += 1 + l + sovProto(uint64(l))
 		}
 	}
+	l = len(m.Signature)
+	if l > 0 {
+		n += 1 + l + sovProto(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -805,6 +984,170 @@ func (m *FileHeader) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Segment", wireType)
+			}
+			m.Segment = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Segment |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StartNum", wireType)
+			}
+			m.StartNum = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StartNum |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 5 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Magic", wireType)
+			}
+			m.Magic = 0
+			if (iNdEx + 4) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Magic = uint32(encoding_binary.LittleEndian.Uint32(dAtA[iNdEx:]))
+			iNdEx += 4
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Format", wireType)
+			}
+			m.Format = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Format |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			m.Version = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Version |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 5 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Checksum", wireType)
+			}
+			m.Checksum = 0
+			if (iNdEx + 4) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Checksum = uint32(encoding_binary.LittleEndian.Uint32(dAtA[iNdEx:]))
+			iNdEx += 4
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Salt", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthProto
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthProto
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Salt = append(m.Salt[:0], dAtA[iNdEx:postIndex]...)
+			if m.Salt == nil {
+				m.Salt = []byte{}
+			}
+			iNdEx = postIndex
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Mac", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthProto
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthProto
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Mac = append(m.Mac[:0], dAtA[iNdEx:postIndex]...)
+			if m.Mac == nil {
+				m.Mac = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipProto(dAtA[iNdEx:])
@@ -1269,6 +1612,25 @@ func (m *LogOperation) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hlc", wireType)
+			}
+			m.Hlc = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Hlc |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipProto(dAtA[iNdEx:])
@@ -1354,6 +1716,40 @@ func (m *LogEntry) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Signature", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowProto
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthProto
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthProto
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Signature = append(m.Signature[:0], dAtA[iNdEx:postIndex]...)
+			if m.Signature == nil {
+				m.Signature = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipProto(dAtA[iNdEx:])
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+const QuarantineFileName = "quarantine.json"
+
+// Quarantine keeps the log of a participant from being replayed, e.g. it has an entry
+// failed crc check or verification, until it's readmitted by the operator after repair.
+// operations replayed before it stay
+type Quarantine struct {
+	MachineID string `json:"machine_id"`
+	Reason    string `json:"reason"`
+	// where the bad entry is, the start of the log if it can't be opened
+	Segment int64     `json:"segment"`
+	Offset  int64     `json:"offset"`
+	Since   time.Time `json:"since"`
+}
+
+func (q *Quarantine) String() string {
+	return fmt.Sprintf("quarantined at segment[%v] offset[%v] since %v: %v",
+		q.Segment, q.Offset, q.Since.Format(time.RFC3339), q.Reason)
+}
+
+func getQuarantineFilePath(personalPath string) string {
+	quarantineFile := path.Join(personalPath, QuarantineFileName)
+	return quarantineFile
+}
+
+func writeQuarantine(filename string, quarantined map[string]*Quarantine) error {
+	if len(quarantined) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(quarantined, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, data)
+}
+
+// readQuarantine returns an empty map if nothing is quarantined
+func readQuarantine(filename string) (map[string]*Quarantine, error) {
+	quarantined := make(map[string]*Quarantine)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return quarantined, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &quarantined)
+	if err != nil {
+		return nil, fmt.Errorf("bad quarantine file[%v]: %v", filename, err)
+	}
+	return quarantined, nil
+}
+
+// initQuarantine loads participants quarantined by me, who is never quarantined
+func (n *NetworkInfo) initQuarantine(me *ParticipantInfo) error {
+	quarantined, err := readQuarantine(me.quarantineFile)
+	if err != nil {
+		return err
+	}
+	delete(quarantined, me.name)
+	n.me = me.name
+	n.quarantineFile = me.quarantineFile
+	n.quarantined = quarantined
+	return nil
+}
+
+func (n *NetworkInfo) isQuarantined(name string) bool {
+	_, ok := n.quarantined[name]
+	return ok
+}
+
+// quarantine stops replaying log of q.MachineID, false if it's me or it's quarantined already
+func (n *NetworkInfo) quarantine(q *Quarantine) bool {
+	if q.MachineID == n.me || n.isQuarantined(q.MachineID) {
+		return false
+	}
+	if n.quarantined == nil {
+		n.quarantined = make(map[string]*Quarantine)
+	}
+	n.quarantined[q.MachineID] = q
+	delete(n.failures, q.MachineID)
+	n.closeWal(q.MachineID)
+	logger.Warn("participant[%v] is %v", q.MachineID, q)
+	if n.quarantineFile != "" {
+		if err := writeQuarantine(n.quarantineFile, n.quarantined); err != nil {
+			logger.Error("write quarantine file[%v] failed[%v]", n.quarantineFile, err)
+		}
+	}
+	return true
+}
+
+// quarantineAttempts is how many times a log fails the same way in a row before
+// it's quarantined, if the failure may be transient
+const quarantineAttempts = 3
+
+type logFailure struct {
+	q        *Quarantine
+	attempts int
+}
+
+// fail records a failure which may be transient, e.g. the log is being copied by a sync
+// client, the log is quarantined only if it fails at the same point repeatedly
+func (n *NetworkInfo) fail(q *Quarantine) bool {
+	if q.MachineID == n.me || n.isQuarantined(q.MachineID) {
+		return false
+	}
+	if n.failures == nil {
+		n.failures = make(map[string]*logFailure)
+	}
+	last, ok := n.failures[q.MachineID]
+	if !ok || last.q.Segment != q.Segment || last.q.Offset != q.Offset || last.q.Reason != q.Reason {
+		n.failures[q.MachineID] = &logFailure{q: q, attempts: 1}
+		logger.Warn("log of participant[%v] failed at segment[%v] offset[%v], retry later: %v",
+			q.MachineID, q.Segment, q.Offset, q.Reason)
+		return false
+	}
+	last.attempts++
+	if last.attempts < quarantineAttempts {
+		return false
+	}
+	// since it failed first
+	q.Since = last.q.Since
+	return n.quarantine(q)
+}
+
+// readmit replays log of name again, its wal is opened again
+func (n *NetworkInfo) readmit(name string) error {
+	if !n.isQuarantined(name) {
+		return fmt.Errorf("participant[%v] is not quarantined", name)
+	}
+	delete(n.quarantined, name)
+	n.closeWal(name)
+	if n.quarantineFile != "" {
+		if err := writeQuarantine(n.quarantineFile, n.quarantined); err != nil {
+			return fmt.Errorf("write quarantine file failed[%v]", err)
+		}
+	}
+	logger.Info("participant[%v] is readmitted", name)
+	return nil
+}
+
+// Quarantined returns participants whose logs are not replayed, by name
+func (p *Participant) Quarantined() map[string]*Quarantine {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := make(map[string]*Quarantine, len(p.network.quarantined))
+	for name, q := range p.network.quarantined {
+		copied := *q
+		results[name] = &copied
+	}
+	return results
+}
+
+// Readmit replays log of a quarantined participant again, from where it was replayed
+// to. it's quarantined again if the log is still bad
+func (p *Participant) Readmit(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.network.readmit(name); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&p.changed, 1)
+	return p.runLogTillEnd()
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParticipantQuarantine(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s1 := Participant{}
+	err := s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	err = s1.Save("testKey1", "testValue1")
+	assert.Nil(t, err)
+	err = s1.Save("testKey2", "testValue2")
+	assert.Nil(t, err)
+	s1.Close()
+
+	// damage the second entry of machine1
+	walFile := getWalFilePath(getPersonalPath("data", "machine1"))
+	second := int64(0)
+	_, err = ScanWal(walFile, nil, func(e *ScannedEntry) error {
+		if second == 0 && e.Offset > HeaderSize {
+			second = e.Offset
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	f, err := OpenFile(walFile, false)
+	assert.Nil(t, err)
+	original := make([]byte, 2)
+	_, err = f.Seek(second+8, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(f, original)
+	assert.Nil(t, err)
+	_, err = f.Seek(second+8, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0xff, 0xff})
+	assert.Nil(t, err)
+
+	s0 := Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	// the rest keeps syncing
+	err = s0.Save("testKey0", "testValue0")
+	assert.Nil(t, err)
+	has, err := s0.Has("testKey1")
+	assert.Nil(t, err)
+	assert.True(t, has)
+	has, err = s0.Has("testKey2")
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	quarantined := s0.Quarantined()
+	assert.Equal(t, 1, len(quarantined))
+	assert.Equal(t, second, quarantined["machine1"].Offset)
+	assert.NotEmpty(t, quarantined["machine1"].Reason)
+	status, err := s0.Status()
+	assert.Nil(t, err)
+	assert.NotNil(t, status["machine1"].Quarantine)
+	assert.Nil(t, status["machine0"].Quarantine)
+
+	// kept across restarts
+	s0.Close()
+	s0 = Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s0.Close()
+	assert.Equal(t, 1, len(s0.Quarantined()))
+	err = s0.Readmit("machine0")
+	assert.NotNil(t, err)
+
+	// readmitted after repair
+	_, err = f.Seek(second+8, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = f.Write(original)
+	assert.Nil(t, err)
+	f.Close()
+	err = s0.Readmit("machine1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(s0.Quarantined()))
+	has, err = s0.Has("testKey2")
+	assert.Nil(t, err)
+	assert.True(t, has)
+	_, err = os.Stat(getQuarantineFilePath(getPersonalPath("data", "machine0")))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParticipantRetryBeforeQuarantine(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s1 := Participant{}
+	err := s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	err = s1.Save("testKey1", "testValue1")
+	assert.Nil(t, err)
+	s1.Close()
+
+	// the header is not copied yet by the sync client
+	walFile := getWalFilePath(getPersonalPath("data", "machine1"))
+	data, err := os.ReadFile(walFile)
+	assert.Nil(t, err)
+	err = os.WriteFile(walFile, data[:HeaderSize/2], 0644)
+	assert.Nil(t, err)
+
+	s0 := Participant{}
+	err = s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s0.Close()
+	has, err := s0.Has("testKey1")
+	assert.Nil(t, err)
+	assert.False(t, has)
+	assert.Equal(t, 0, len(s0.Quarantined()))
+
+	// copied later
+	err = os.WriteFile(walFile, data, 0644)
+	assert.Nil(t, err)
+	has, err = s0.Has("testKey1")
+	assert.Nil(t, err)
+	assert.True(t, has)
+	assert.Equal(t, 0, len(s0.Quarantined()))
+	assert.Equal(t, 0, len(s0.network.failures))
+
+	// never repaired
+	err = os.WriteFile(walFile, data[:HeaderSize/2], 0644)
+	assert.Nil(t, err)
+	for i := 0; i < quarantineAttempts-1; i++ {
+		_, err = s0.Has("testKey1")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(s0.Quarantined()))
+	}
+	_, err = s0.Has("testKey1")
+	assert.Nil(t, err)
+	quarantined := s0.Quarantined()
+	assert.Equal(t, 1, len(quarantined))
+	assert.NotNil(t, quarantined["machine1"])
+}
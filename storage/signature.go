@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// PublicKeyFileName is where a participant publishes its public key, hex encoded
+const PublicKeyFileName = "public_key"
+
+func getPublicKeyFilePath(personalPath string) string {
+	publicKeyFile := path.Join(personalPath, PublicKeyFileName)
+	return publicKeyFile
+}
+
+// LoadOrCreateSigningKey reads the hex encoded seed in filename, a new key is
+// created if it doesn't exist. keep it out of the shared directory
+func LoadOrCreateSigningKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid signing key file[%v]", filename)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filename, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return hex.EncodeToString(key)
+}
+
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size[%v]", len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+func writePublicKey(filename string, key ed25519.PublicKey) error {
+	return WriteFileAtomic(filename, []byte(EncodePublicKey(key)+"\n"))
+}
+
+// publishPublicKey writes key to filename unless it's already there
+func publishPublicKey(filename string, key ed25519.PublicKey) error {
+	published, err := readPublicKey(filename)
+	if err == nil && published != nil && published.Equal(key) {
+		return nil
+	}
+	return writePublicKey(filename, key)
+}
+
+// readPublicKey returns nil if nothing has been published
+func readPublicKey(filename string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ParsePublicKey(string(data))
+}
+
+// PublicKeys reads public keys published by all participants,
+// those having published nothing or something unreadable are absent.
+// they are what participants claim, check them before trusting
+func (n *NetworkInfo) PublicKeys() map[string]ed25519.PublicKey {
+	results := make(map[string]ed25519.PublicKey)
+	for name, p := range n.participants {
+		key, err := readPublicKey(p.publicKeyFile)
+		if err != nil {
+			logger.Warn("read public key of participant[%v] failed[%v]", name, err)
+			continue
+		}
+		if key == nil {
+			continue
+		}
+		results[name] = key
+	}
+	return results
+}
+
+func entrySignData(entry *LogEntry) ([]byte, error) {
+	unsigned := LogEntry{Ops: entry.Ops}
+	return unsigned.Marshal()
+}
+
+func signEntry(key ed25519.PrivateKey, entry *LogEntry) error {
+	data, err := entrySignData(entry)
+	if err != nil {
+		return err
+	}
+	entry.Signature = ed25519.Sign(key, data)
+	return nil
+}
+
+// verifyEntry checks entry is signed by key, and its operations are made by owner
+// of the log, numbered on from prevNum, 0 if it's unknown. a signed entry can't be
+// moved to the log of another participant or replayed elsewhere in the log
+func verifyEntry(key ed25519.PublicKey, owner string, prevNum int64, entry *LogEntry) error {
+	if len(entry.Signature) == 0 {
+		return fmt.Errorf("entry not signed")
+	}
+	data, err := entrySignData(entry)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, entry.Signature) {
+		return fmt.Errorf("signature mismatch")
+	}
+	for _, op := range entry.Ops {
+		if op.MachineId != owner {
+			return fmt.Errorf("operation[%v] of [%v] in log of [%v]", op.Gid, op.MachineId, owner)
+		}
+		if prevNum > 0 && op.Num != prevNum+1 {
+			return fmt.Errorf("num[%v] of operation[%v] follows num[%v]", op.Num, op.Gid, prevNum)
+		}
+		prevNum = op.Num
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSigningKey(t assert.TestingT) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return key
+}
+
+func TestLoadOrCreateSigningKey(t *testing.T) {
+	const keyFile = "test_signing_key"
+	os.Remove(keyFile)
+	t.Cleanup(func() { os.Remove(keyFile) })
+
+	key, err := LoadOrCreateSigningKey(keyFile)
+	assert.Nil(t, err)
+	loaded, err := LoadOrCreateSigningKey(keyFile)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(loaded))
+
+	public := key.Public().(ed25519.PublicKey)
+	parsed, err := ParsePublicKey(EncodePublicKey(public))
+	assert.Nil(t, err)
+	assert.True(t, public.Equal(parsed))
+	_, err = ParsePublicKey("abcd")
+	assert.NotNil(t, err)
+}
+
+func TestSignEntry(t *testing.T) {
+	key := newTestSigningKey(t)
+	public := key.Public().(ed25519.PublicKey)
+	entry := LogEntry{Ops: []*LogOperation{
+		{Op: int32(Op_Modify), Key: "key", Value: "value", MachineId: "machine0", Num: 3},
+		{Op: int32(Op_Modify), Key: "key", Value: "value", MachineId: "machine0", Num: 4}}}
+	assert.NotNil(t, verifyEntry(public, "machine0", 2, &entry))
+
+	err := signEntry(key, &entry)
+	assert.Nil(t, err)
+	assert.Nil(t, verifyEntry(public, "machine0", 2, &entry))
+	assert.Nil(t, verifyEntry(public, "machine0", 0, &entry))
+	assert.NotNil(t, verifyEntry(newTestSigningKey(t).Public().(ed25519.PublicKey), "machine0", 2, &entry))
+	// in the log of another participant
+	assert.NotNil(t, verifyEntry(public, "machine1", 2, &entry))
+	// replayed after later operations
+	assert.NotNil(t, verifyEntry(public, "machine0", 4, &entry))
+
+	entry.Ops[0].Value = "tampered"
+	assert.NotNil(t, verifyEntry(public, "machine0", 2, &entry))
+}
+
+func TestWalVerify(t *testing.T) {
+	defer delWalFile()
+	key := newTestSigningKey(t)
+	for _, l := range []LogFormat{&BinLog{}, &JsonLog{}} {
+		wal := Wal{}
+		err := wal.Init(getWalFile(), l, false)
+		assert.Nil(t, err)
+		wal.SetSigner(key)
+		_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "key", Value: "value", MachineId: "machine0"})
+		assert.Nil(t, err)
+		wal.SetSigner(newTestSigningKey(t))
+		_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "key", Value: "forged", MachineId: "machine0"})
+		assert.Nil(t, err)
+		wal.Close()
+
+		wal = Wal{}
+		err = wal.Init(walFileName, l, true)
+		assert.Nil(t, err)
+		wal.SetVerifier("machine0", key.Public().(ed25519.PublicKey))
+		i := wal.Iterator()
+		assert.True(t, i.Next())
+		assert.Equal(t, "value", i.LogOp().Value)
+		assert.False(t, i.Next())
+		assert.NotNil(t, i.Err())
+		wal.Close()
+	}
+}
+
+func TestWalVerifyOwner(t *testing.T) {
+	defer delWalFile()
+	key := newTestSigningKey(t)
+	wal := Wal{}
+	err := wal.Init(getWalFile(), nil, false)
+	assert.Nil(t, err)
+	wal.SetSigner(key)
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "key", Value: "value", MachineId: "machine0"})
+	assert.Nil(t, err)
+	// signed by the owner, but claimed to be made by another participant
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "key", Value: "forged", MachineId: "machine1"})
+	assert.Nil(t, err)
+	wal.Close()
+
+	wal = Wal{}
+	err = wal.Init(walFileName, nil, true)
+	assert.Nil(t, err)
+	defer wal.Close()
+	wal.SetVerifier("machine0", key.Public().(ed25519.PublicKey))
+	i := wal.Iterator()
+	assert.True(t, i.Next())
+	assert.Equal(t, "value", i.LogOp().Value)
+	assert.False(t, i.Next())
+	assert.NotNil(t, i.Err())
+}
+
+func TestParticipantTrustedKeys(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	key0 := newTestSigningKey(t)
+	key1 := newTestSigningKey(t)
+	s0 := Participant{}
+	err := s0.InitWithOptions("data", "machine0", &ParticipantOptions{SigningKey: key0})
+	assert.Nil(t, err)
+	err = s0.Save("testKey", "testValue")
+	assert.Nil(t, err)
+	s0.Close()
+
+	// a participant nobody trusts
+	evil := Participant{}
+	err = evil.Init("data", "evil")
+	assert.Nil(t, err)
+	err = evil.Save("evilKey", "evilValue")
+	assert.Nil(t, err)
+	evil.Close()
+
+	trusted := map[string]ed25519.PublicKey{"machine0": key0.Public().(ed25519.PublicKey)}
+	s1 := Participant{}
+	err = s1.InitWithOptions("data", "machine1", &ParticipantOptions{SigningKey: key1, TrustedKeys: trusted})
+	assert.Nil(t, err)
+	defer s1.Close()
+	records, err := s1.All()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, [][2]string{{"testKey", "testValue"}}, valuesToArray(records))
+	assert.False(t, s1.Trusted("evil"))
+
+	published := s1.PublicKeys()
+	assert.True(t, published["machine0"].Equal(key0.Public()))
+	assert.True(t, published["machine1"].Equal(key1.Public()))
+	_, ok := published["evil"]
+	assert.False(t, ok)
+
+	s2 := Participant{}
+	err = s2.InitWithOptions("data", "machine2", &ParticipantOptions{TrustedKeys: trusted})
+	assert.NotNil(t, err)
+}
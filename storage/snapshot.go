@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Snapshot is the dataset when logs of participants were replayed to some points,
+// it's read-only and independent of later writes
+type Snapshot struct {
+	ns *NodeStorageImpl
+	me string
+	at map[string]*LogProgress
+}
+
+// Storage returns the nodes replayed
+func (s *Snapshot) Storage() ReadOnlyNodeStorage {
+	return s.ns
+}
+
+// Progress returns where the log of machineID is replayed to
+func (s *Snapshot) Progress(machineID string) *LogProgress {
+	if progress, ok := s.at[machineID]; ok {
+		return progress
+	}
+	return newLogProgress(machineID)
+}
+
+func (s *Snapshot) Load(key string) (*Value, error) {
+	return loadValue(s.ns, key, s.me)
+}
+
+func (s *Snapshot) All() ([]*Value, error) {
+	return allValues(s.ns, s.me)
+}
+
+// SnapshotAt replays logs into a fresh storage, each stops at its point in at,
+// participants not in at are not replayed. it fails if a point can't be reached,
+// e.g. it's not in the log, or it depends on operations after other points
+func (p *Participant) SnapshotAt(at map[string]StopPoint) (*Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+
+	inputs := []*LogInput{}
+	for name, stop := range at {
+		participant, ok := p.network.participants[name]
+		if !ok {
+			return nil, fmt.Errorf("participant[%v] not found", name)
+		}
+		key, trusted := p.network.verifier(name)
+		if !trusted {
+			return nil, fmt.Errorf("participant[%v] is not trusted", name)
+		}
+		w, err := p.network.openWal(participant, p.l)
+		if err != nil {
+			return nil, err
+		}
+		w.SetVerifier(name, key)
+		stop := stop
+		inputs = append(inputs, &LogInput{machineID: name, w: w,
+			progress: newLogProgress(name), stop: &stop})
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("empty stop points")
+	}
+
+	ns := NodeStorageImpl{}
+	ns.Init()
+	runner := LogRunner{}
+	err := runner.Init(p.me.name, &ns)
+	if err != nil {
+		return nil, err
+	}
+	results, err := runner.Run(inputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := make(map[string]*LogProgress)
+	unreached := []string{}
+	for _, input := range inputs {
+		name := input.machineID
+		progress[name] = results.Process(name)
+		if input.stop.reached(progress[name]) {
+			continue
+		}
+		reason := fmt.Sprintf("[%v] is replayed to num[%v], not %v", name, progress[name].Num, input.stop)
+		if b, ok := results.Blocked()[name]; ok {
+			reason += ", " + b.String()
+		} else if err, ok := results.Failed()[name]; ok {
+			reason += ", " + err.Error()
+		}
+		unreached = append(unreached, reason)
+	}
+	if len(unreached) > 0 {
+		sort.Strings(unreached)
+		return nil, fmt.Errorf("stop points unreached: %v", strings.Join(unreached, "; "))
+	}
+	return &Snapshot{ns: &ns, me: p.me.name, at: progress}, nil
+}
+
+// StopPoints returns how far the publisher has replayed every log as stop points,
+// so its view can be reproduced by SnapshotAt
+func (p *PublishedProgress) StopPoints() map[string]StopPoint {
+	results := make(map[string]StopPoint, len(p.Replayed))
+	for name, progress := range p.Replayed {
+		if progress == nil {
+			continue
+		}
+		results[name] = StopPoint{Num: progress.Num, Gid: progress.Gid}
+	}
+	return results
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParticipantSnapshotAt(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+
+	s0 := Participant{}
+	err := s0.Init("data", "machine0")
+	assert.Nil(t, err)
+	defer s0.Close()
+	s1 := Participant{}
+	err = s1.Init("data", "machine1")
+	assert.Nil(t, err)
+	defer s1.Close()
+
+	err = s0.Save("testKey0", "v1")
+	assert.Nil(t, err)
+	err = s0.Save("testKey0", "v2")
+	assert.Nil(t, err)
+	err = s1.Save("testKey1", "v1")
+	assert.Nil(t, err)
+	v, err := s1.Load("testKey1")
+	assert.Nil(t, err)
+	gid := v.Main().gid
+	err = s1.Save("testKey1", "v2")
+	assert.Nil(t, err)
+	// machine1 num 3 depends on machine0 num 2
+	err = s1.Save("testKey0", "v3")
+	assert.Nil(t, err)
+
+	snapshot, err := s0.SnapshotAt(map[string]StopPoint{"machine0": {Num: 1}, "machine1": {Gid: gid}})
+	assert.Nil(t, err)
+	v, err = snapshot.Load("testKey0")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", v.Main().value)
+	v, err = snapshot.Load("testKey1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", v.Main().value)
+	assert.Equal(t, int64(1), snapshot.Progress("machine1").Num)
+
+	// the log of machine0 is not replayed
+	snapshot, err = s0.SnapshotAt(map[string]StopPoint{"machine1": {Num: 2}})
+	assert.Nil(t, err)
+	all, err := snapshot.All()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(all))
+
+	_, err = s0.SnapshotAt(map[string]StopPoint{"machine0": {Num: 1}, "machine1": {Num: 3}})
+	assert.NotNil(t, err)
+	_, err = s0.SnapshotAt(map[string]StopPoint{"machine0": {Num: 5}})
+	assert.NotNil(t, err)
+	_, err = s0.SnapshotAt(map[string]StopPoint{"machine0": {Gid: "nothing"}})
+	assert.NotNil(t, err)
+
+	// reproduce the view of machine1
+	_, err = s1.All()
+	assert.Nil(t, err)
+	err = s1.PublishProgress()
+	assert.Nil(t, err)
+	published := s0.PeerProgress()["machine1"]
+	snapshot, err = s0.SnapshotAt(published.StopPoints())
+	assert.Nil(t, err)
+	v, err = snapshot.Load("testKey0")
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", v.Main().value)
+	assert.Equal(t, int64(3), snapshot.Progress("machine1").Num)
+
+	// later writes are not seen
+	err = s0.Save("testKey0", "v4")
+	assert.Nil(t, err)
+	v, err = snapshot.Load("testKey0")
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", v.Main().value)
+}
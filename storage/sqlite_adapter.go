@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	// "github.com/glebarez/sqlite" // Pure go SQLite driver, checkout https://github.com/glebarez/sqlite for details
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ChangeCount map[string]int32
@@ -30,13 +32,12 @@ func (n ChangeCount) Value() (driver.Value, error) {
 	return json.Marshal(n)
 }
 
-// TODO able to open sqlite for readonly?
-
 // is_deleted || is_discarded can be removed from storage any time
 type DBRecord struct {
 	Key                string      `gorm:"index;column:key"`
 	Value              string      `gorm:"column:value"`
 	MachineID          string      `gorm:"column:machine_id"`
+	Segment            int64       `gorm:"column:segment"`
 	Offset             int64       `gorm:"column:offset"`
 	PrevMachineID      string      `gorm:"column:prev_machine_id"`
 	Seq                uint64      `gorm:"column:seq"`
@@ -47,6 +48,7 @@ type DBRecord struct {
 	MachineChangeCount ChangeCount `gorm:"column:change_count"`
 	Num                int64       `gorm:"num"`
 	PrevNum            int64       `gorm:"prev_num"`
+	HLC                int64       `gorm:"index;column:hlc"` // 0 if logged before timestamps were introduced
 	CreatedAt          time.Time
 	UpdatedAt          time.Time
 	DeletedAt          sql.NullTime `gorm:"index"`
@@ -54,10 +56,12 @@ type DBRecord struct {
 
 // call newLogProgress to make instance
 type LogProgress struct {
-	Offset    int64  `gorm:"column:offset"` // HeaderSize should be used as initial value
-	Num       int64  `gorm:"column:num"`
-	Gid       string `gorm:"column:gid"`
-	MachineID string `gorm:"uniqueIndex;column:machine_id"`
+	Segment   int64     `gorm:"column:segment"`
+	Offset    int64     `gorm:"column:offset"` // HeaderSize should be used as initial value
+	Num       int64     `gorm:"column:num"`
+	Gid       string    `gorm:"column:gid"`
+	MachineID string    `gorm:"uniqueIndex;column:machine_id"`
+	AppliedAt time.Time `gorm:"-"` // when the last entry was applied, only in memory
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt sql.NullTime `gorm:"index"`
@@ -129,6 +133,26 @@ func (s *SqliteAdapter) Init(dbFile string) error {
 	return nil
 }
 
+// InitReadonly opens an existing db without migrating it, e.g. db of other participants
+func (s *SqliteAdapter) InitReadonly(dbFile string) error {
+	if !IsFile(dbFile) {
+		return fmt.Errorf("db file[%v] not exist", dbFile)
+	}
+	l := gormLoggerImpl{}
+	l.Init(logger)
+	db, err := gorm.Open(sqlite.Open("file:"+dbFile+"?mode=ro"), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 &l,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.db = db
+	s.workingDB = db
+	return nil
+}
+
 func (s *SqliteAdapter) Close() error {
 	if s.db != nil {
 		db, err := s.db.DB()
@@ -182,7 +206,7 @@ func (s *SqliteAdapter) Add(record *DBRecord) error {
 		if err := s2.workingDB.Model(&DBRecord{}).Create(record).Error; err != nil {
 			return err
 		}
-		return s2.updateLogProgress(&LogProgress{MachineID: record.MachineID, Offset: record.Offset,
+		return s2.updateLogProgress(&LogProgress{MachineID: record.MachineID, Segment: record.Segment, Offset: record.Offset,
 			Gid: record.CurrentLogGid, Num: record.Num})
 	})
 }
@@ -211,7 +235,7 @@ func (s *SqliteAdapter) Replace(old string, new *DBRecord) error {
 		if err := s2.workingDB.Model(&DBRecord{}).Create(new).Error; err != nil {
 			return err
 		}
-		return s2.updateLogProgress(&LogProgress{MachineID: new.MachineID, Offset: new.Offset,
+		return s2.updateLogProgress(&LogProgress{MachineID: new.MachineID, Segment: new.Segment, Offset: new.Offset,
 			Gid: new.CurrentLogGid, Num: new.Num})
 	})
 }
@@ -232,6 +256,9 @@ func (s *SqliteAdapter) GetByKey(key string) ([]*DBRecord, error) {
 func (s *SqliteAdapter) GetByGid(gid string) (*DBRecord, error) {
 	rec := DBRecord{}
 	result := s.workingDB.Model(&DBRecord{}).Where("gid = ?", gid).First(&rec)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -260,6 +287,92 @@ func (s *SqliteAdapter) Merge(other ReadOnlyNodeStorage) error {
 	return fmt.Errorf("unsupported")
 }
 
+// sqliteBatch writes in one transaction without checking existence before writes or
+// updating progress per record, statements are prepared once and closed with it
+type sqliteBatch struct {
+	s     SqliteAdapter // reads in the transaction
+	tx    *gorm.DB
+	stmts *gorm.PreparedStmtDB
+}
+
+func (s *SqliteAdapter) Begin() (NodeBatch, error) {
+	db := s.db.Session(&gorm.Session{PrepareStmt: true})
+	// statements prepared in the session are listed in it, they're closed with the batch
+	stmts, ok := db.Statement.ConnPool.(*gorm.PreparedStmtDB)
+	if !ok {
+		return nil, fmt.Errorf("prepare statements unsupported")
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		stmts.Close()
+		return nil, tx.Error
+	}
+	return &sqliteBatch{s: SqliteAdapter{workingDB: tx}, tx: tx, stmts: stmts}, nil
+}
+
+func (b *sqliteBatch) GetByKey(key string) ([]*DBRecord, error) {
+	return b.s.GetByKey(key)
+}
+
+func (b *sqliteBatch) GetByGid(gid string) (*DBRecord, error) {
+	return b.s.GetByGid(gid)
+}
+
+func (b *sqliteBatch) AllNodes() ([]*DBRecord, error) {
+	return b.s.AllNodes()
+}
+
+// Add relies on the unique index of gid
+func (b *sqliteBatch) Add(record *DBRecord) error {
+	return b.tx.Model(&DBRecord{}).Create(record).Error
+}
+
+func (b *sqliteBatch) Replace(old string, new *DBRecord) error {
+	result := b.tx.Model(&DBRecord{}).Where("gid = ?", old).Delete(&DBRecord{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("old %w", ErrNotFound)
+	}
+	return b.tx.Model(&DBRecord{}).Create(new).Error
+}
+
+// Remove deletes the record of gid, nothing is done if it doesn't exist
+func (b *sqliteBatch) Remove(gid string) error {
+	return b.tx.Model(&DBRecord{}).Where("gid = ?", gid).Delete(&DBRecord{}).Error
+}
+
+func (b *sqliteBatch) Merge(other ReadOnlyNodeStorage) error {
+	return fmt.Errorf("unsupported")
+}
+
+// Commit upserts progress by machine and commits the transaction, it's rolled back
+// if anything fails
+func (b *sqliteBatch) Commit(progress []*LogProgress) error {
+	defer b.stmts.Close()
+	for _, p := range progress {
+		err := b.tx.Model(&LogProgress{}).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "machine_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"segment", "offset", "num", "gid", "updated_at"}),
+		}).Create(p).Error
+		if err != nil {
+			if e := b.tx.Rollback().Error; e != nil {
+				logger.Error("rollback batch failed[%v]", e)
+			}
+			return err
+		}
+	}
+	return b.tx.Commit().Error
+}
+
+func (b *sqliteBatch) Rollback() error {
+	defer b.stmts.Close()
+	return b.tx.Rollback().Error
+}
+
 func _() {
 	var _ NodeStorage = &SqliteAdapter{}
+	var _ BatchNodeStorage = &SqliteAdapter{}
+	var _ NodeBatch = &sqliteBatch{}
 }
@@ -1,10 +1,13 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 const fileName = "test.db"
@@ -278,3 +281,80 @@ func delDBFile() {
 // 	assert.Nil(t, err)
 // 	assert.False(t, has)
 // }
+
+func TestSqliteBatch(t *testing.T) {
+	t.Cleanup(delDBFile)
+	a := getDB(t)
+	defer a.Close()
+
+	b, err := a.Begin()
+	assert.Nil(t, err)
+	err = b.Add(&DBRecord{Key: "testKey", Value: "v1", CurrentLogGid: "gid1", MachineID: "machine0", Num: 1})
+	assert.Nil(t, err)
+	_, err = b.GetByGid("gid1")
+	assert.Nil(t, err)
+	err = b.Rollback()
+	assert.Nil(t, err)
+	_, err = a.GetByGid("gid1")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	b, err = a.Begin()
+	assert.Nil(t, err)
+	err = b.Add(&DBRecord{Key: "testKey", Value: "v1", CurrentLogGid: "gid1", MachineID: "machine0", Num: 1})
+	assert.Nil(t, err)
+	err = b.Replace("gid1", &DBRecord{Key: "testKey", Value: "v2", CurrentLogGid: "gid2", MachineID: "machine0", Num: 2})
+	assert.Nil(t, err)
+	err = b.Replace("nothing", &DBRecord{Key: "testKey", Value: "v3", CurrentLogGid: "gid3", MachineID: "machine0", Num: 3})
+	assert.True(t, errors.Is(err, ErrNotFound))
+	// statements are prepared in the batch only
+	stmts := b.(*sqliteBatch).stmts
+	assert.NotEmpty(t, stmts.PreparedSQL)
+	_, ok := a.db.Statement.ConnPool.(*gorm.PreparedStmtDB)
+	assert.False(t, ok)
+	err = b.Commit([]*LogProgress{{MachineID: "machine0", Segment: 0, Offset: 100, Num: 2, Gid: "gid2"}})
+	assert.Nil(t, err)
+	assert.Empty(t, stmts.Stmts)
+
+	record, err := a.GetByGid("gid2")
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", record.Value)
+	_, err = a.GetByGid("gid1")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// progress is upserted
+	b, err = a.Begin()
+	assert.Nil(t, err)
+	err = b.Commit([]*LogProgress{{MachineID: "machine0", Segment: 1, Offset: 200, Num: 5, Gid: "gid5"}})
+	assert.Nil(t, err)
+	processes, err := a.Processes()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(processes))
+	assert.Equal(t, int64(5), processes[0].Num)
+	assert.Equal(t, int64(1), processes[0].Segment)
+	assert.Equal(t, "gid5", processes[0].Gid)
+
+	b, err = a.Begin()
+	assert.Nil(t, err)
+	err = b.Remove("gid2")
+	assert.Nil(t, err)
+	err = b.Remove("nothing")
+	assert.Nil(t, err)
+	err = b.Commit(nil)
+	assert.Nil(t, err)
+	_, err = a.GetByGid("gid2")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// it's rolled back if progress fails to write
+	b, err = a.Begin()
+	assert.Nil(t, err)
+	err = b.Add(&DBRecord{Key: "testKey", Value: "v4", CurrentLogGid: "gid4", MachineID: "machine0", Num: 4})
+	assert.Nil(t, err)
+	stmts = b.(*sqliteBatch).stmts
+	err = b.(*sqliteBatch).tx.Migrator().DropTable(&LogProgress{})
+	assert.Nil(t, err)
+	err = b.Commit([]*LogProgress{{MachineID: "machine0", Segment: 1, Offset: 300, Num: 6, Gid: "gid6"}})
+	assert.NotNil(t, err)
+	assert.Empty(t, stmts.Stmts)
+	_, err = a.GetByGid("gid4")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"time"
+)
+
+// ReplayStatus is how far the log of a participant has been replayed
+type ReplayStatus struct {
+	MachineID string
+	Trusted   bool
+	// replayed to
+	Num int64
+	// in its log when it's replayed
+	EntryNum int64
+	// when the last operation is applied, zero if nothing is applied since Init
+	AppliedAt time.Time
+	// nil unless it waits for an operation of others
+	Blocked *BlockedReplay
+	// why it stopped, e.g. storage failed
+	Err error
+	// nil unless its log is not replayed until readmitted
+	Quarantine *Quarantine
+}
+
+// Behind tells if its log has operations not replayed
+func (s *ReplayStatus) Behind() int64 {
+	return s.EntryNum - s.Num
+}
+
+// Status replays logs and tells how replay of every participant goes, by machine
+func (p *Participant) Status() (map[string]*ReplayStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.discover(); err != nil {
+		return nil, err
+	}
+	if err := p.runLogTillEnd(); err != nil {
+		// what's stuck is what's asked for
+		logger.Warn("replay failed[%v]", err)
+	}
+
+	results := make(map[string]*ReplayStatus)
+	for name := range p.network.participants {
+		_, trusted := p.network.verifier(name)
+		status := ReplayStatus{MachineID: name, Trusted: trusted}
+		progress := p.m.Get(name)
+		status.Num = progress.Num
+		status.AppliedAt = progress.AppliedAt
+		if w, ok := p.network.wals[name]; ok {
+			status.EntryNum = w.EntryNum()
+		}
+		if p.lastResult != nil {
+			status.Blocked = p.lastResult.Blocked()[name]
+			status.Err = p.lastResult.Failed()[name]
+		}
+		if q, ok := p.network.quarantined[name]; ok {
+			copied := *q
+			status.Quarantine = &copied
+		}
+		results[name] = &status
+	}
+	return results, nil
+}
@@ -54,6 +54,16 @@ type ValueVersion struct {
 	machineID string
 	gid       string
 	seq       int
+	// when it's made, zero if unknown
+	timestamp HLCTimestamp
+}
+
+func (v *ValueVersion) Value() string {
+	return v.value
+}
+
+func (v *ValueVersion) Timestamp() HLCTimestamp {
+	return v.timestamp
 }
 
 func (v *ValueVersion) String() string {
@@ -82,7 +92,7 @@ func (v *Value) from(leaves []*DBRecord, machineID string) error {
 	v.versions = append(v.versions,
 		&ValueVersion{key: main.Key, value: main.Value,
 			gid: main.CurrentLogGid, machineID: main.MachineID,
-			seq: 0})
+			seq: 0, timestamp: HLCTimestamp(main.HLC)})
 
 	seq := 1
 	for _, e := range leaves {
@@ -95,7 +105,7 @@ func (v *Value) from(leaves []*DBRecord, machineID string) error {
 		v.versions = append(v.versions,
 			&ValueVersion{key: e.Key, value: e.Value,
 				machineID: e.MachineID, gid: e.CurrentLogGid,
-				seq: seq})
+				seq: seq, timestamp: HLCTimestamp(e.HLC)})
 		seq++
 	}
 	return nil
@@ -156,6 +166,8 @@ func valuesToArray(v []*Value) [][2]string {
 
 // Storage ...
 type Storage interface {
+	// Save and Del may return ErrNotDurable if the change is written but not fsynced,
+	// they're not to be retried then
 	Save(key string, value string) error
 	Del(key string) error
 	Has(key string) (bool, error)
@@ -60,3 +60,25 @@ func ToAbs(p string) (string, error) {
 	p = path.Clean(p)
 	return p, nil
 }
+
+// WriteFileAtomic replaces filename with data, readers see either the old
+// content or the new one
+func WriteFileAtomic(filename string, data []byte) error {
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -1,45 +1,119 @@
 package storage
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	gogoproto "github.com/gogo/protobuf/proto"
 )
 
+// thresholds past which Wal.Append rolls over to a new segment, <= 0 means no limit
+const DefaultSegmentSize = 4 << 20
+const DefaultSegmentEntries = 16384
+
+// segment 0 is stored in the wal file itself, segment n in <name>.<n><ext>,
+// e.g. 0.wal, 0.1.wal, 0.2.wal
+func segmentFileName(filename string, segment int64) string {
+	if segment == 0 {
+		return filename
+	}
+	ext := path.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + strconv.FormatInt(segment, 10) + ext
+}
+
+// listSegments returns existing segments of the wal in ascending order
+func listSegments(filename string) ([]int64, error) {
+	dir, base := path.Split(filename)
+	if len(dir) == 0 {
+		dir = "."
+	}
+	ext := path.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	segments := []int64{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := e.Name()
+		if name == base {
+			segments = append(segments, 0)
+			continue
+		}
+		if len(name) <= len(prefix)+len(ext) ||
+			!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		segment, err := strconv.ParseInt(name[len(prefix):len(name)-len(ext)], 10, 64)
+		if err != nil || segment <= 0 {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func walExists(filename string) bool {
+	segments, err := listSegments(filename)
+	return err == nil && len(segments) > 0
+}
+
 type Iterator interface {
 	Next() bool
 }
 
 type WalIterator struct {
-	w      *Wal
-	pos    int64
-	endPos int64 //const
-	entry  *LogEntry
-	index  int
+	w       *Wal
+	segment int64
+	f       File
+	l       LogFormat
+	pos     int64
+	endPos  int64 // const within a segment
+	entry   *LogEntry
+	index   int
+	lastNum int64 // of the last operation read, 0 if no entry is read yet
 
 	stoped bool
+	err    error
 
 	end        string
 	includeEnd bool
 }
 
 func (i *WalIterator) Init(w *Wal) {
-	i.w = w
-	i.pos = HeaderSize
-	i.endPos = w.header.FileEnd
-	i.entry = nil
-	i.index = 0
+	i.InitAt(w, w.firstSegment(), HeaderSize)
 }
 
-func (i *WalIterator) InitWithOffset(w *Wal, offset int64) {
+// InitAt starts iterating from offset of segment
+func (i *WalIterator) InitAt(w *Wal, segment int64, offset int64) {
 	if offset < HeaderSize {
 		offset = HeaderSize
 	}
 	i.w = w
+	i.segment = segment
+	i.f = nil
+	i.l = nil
 	i.pos = offset
-	i.endPos = w.header.FileEnd
+	i.endPos = 0
 	i.entry = nil
 	i.index = 0
+	i.lastNum = 0
+	i.err = nil
 }
 
 func (i *WalIterator) Next() (hasNext bool) {
@@ -66,24 +140,56 @@ func (i *WalIterator) Next() (hasNext bool) {
 	}
 
 	var entry *LogEntry = nil
-	for i.pos < i.endPos {
+	for entry == nil {
+		if i.f == nil {
+			f, l, endPos, err := i.w.segmentFile(i.segment)
+			if err != nil {
+				logger.Error("open segment[%v] of wal[%v] failed[%v]", i.segment, i.w.filename, err)
+				i.err = err
+				return false
+			}
+			i.f = f
+			i.l = l
+			i.endPos = endPos
+			if i.lastNum == 0 && i.pos == HeaderSize {
+				i.lastNum = i.w.startNum(i.segment)
+			}
+		}
+
+		if i.pos >= i.endPos {
+			next, ok := i.w.nextSegment(i.segment)
+			if !ok {
+				return false
+			}
+			i.segment = next
+			i.f = nil
+			i.pos = HeaderSize
+			i.endPos = 0
+			continue
+		}
+
 		tmp := LogEntry{}
-		readSz, err := i.w.l.ReadEntry(i.w.f, i.pos, &tmp)
+		readSz, err := i.l.ReadEntry(i.f, i.pos, &tmp)
 		if err != nil {
+			i.err = fmt.Errorf("read segment[%v] of wal[%v] failed[%v]", i.segment, i.w.filename, err)
 			return false
 		}
 		if readSz <= 0 {
 			return false
 		}
-		i.pos += readSz
 
 		if len(tmp.Ops) > 0 {
+			if i.w.verifier != nil {
+				err = verifyEntry(i.w.verifier, i.w.owner, i.lastNum, &tmp)
+				if err != nil {
+					i.err = fmt.Errorf("reject entry at segment[%v] offset[%v] of wal[%v]: %v", i.segment, i.pos, i.w.filename, err)
+					return false
+				}
+			}
 			entry = &tmp
-			break
+			i.lastNum = tmp.Ops[len(tmp.Ops)-1].Num
 		}
-	}
-	if entry == nil {
-		return false
+		i.pos += readSz
 	}
 
 	i.entry = entry
@@ -91,6 +197,11 @@ func (i *WalIterator) Next() (hasNext bool) {
 	return true
 }
 
+// Err returns why iteration stopped early, nil if it reached the end
+func (i *WalIterator) Err() error {
+	return i.err
+}
+
 func (i *WalIterator) LogOp() *LogOperation {
 	if i.entry == nil || i.index >= len(i.entry.Ops) {
 		panic("error state")
@@ -98,22 +209,101 @@ func (i *WalIterator) LogOp() *LogOperation {
 	return i.entry.Ops[i.index]
 }
 
+// Offset is the position after current entry, in the segment returned by Segment
 func (i *WalIterator) Offset() int64 {
 	return i.pos
 }
 
-type Wal struct {
+func (i *WalIterator) Segment() int64 {
+	return i.segment
+}
+
+// End is where entries of the current segment end by its header, 0 if it's not opened
+func (i *WalIterator) End() int64 {
+	return i.endPos
+}
+
+type walSegment struct {
 	f      File
 	l      LogFormat
 	header *FileHeader
-	pos    int64
-	broken bool
+	index  *walIndex // loaded on demand
+}
+
+type Wal struct {
+	filename string
+	f        File        // the last segment, which is the only one to append
+	l        LogFormat   // format of the last segment
+	header   *FileHeader // header of the last segment
+	pos      int64
+	broken   bool
 
 	readonly bool
+	mmap     bool // readonly files are mapped
+
+	segment  int64                 // index of the last segment
+	segments []int64               // all segments in ascending order
+	sealed   map[int64]*walSegment // opened segments other than the last one
+
+	maxSegmentSize    int64
+	maxSegmentEntries int64
+
+	signer   ed25519.PrivateKey // signs appended entries if set
+	verifier ed25519.PublicKey  // iterators stop at entries not signed by it if set
+	owner    string             // whose operations are verified
+	clock    *HLC               // stamps appended operations
+
+	index     *walIndex // index of the last segment, loaded on demand
+	indexFile *os.File  // index of the last segment to append, nil if unavailable
+
+	lastStat os.FileInfo // of the last segment when a readonly wal is opened or refreshed
+}
+
+// Init opens the wal, format of existing files is detected, l is used to create
+// new files and preferred if it matches the detected format, l can be nil
+func (w *Wal) Init(filename string, l LogFormat, readonly bool) error {
+	return w.init(filename, l, readonly, false)
+}
+
+// InitMmap opens the wal readonly, its files are read by mmap where supported
+func (w *Wal) InitMmap(filename string, l LogFormat) error {
+	return w.init(filename, l, true, true)
 }
 
-func (w *Wal) Init(filename string, l LogFormat, readonly bool) (err error) {
-	f, err := OpenFile(filename, readonly)
+func (w *Wal) init(filename string, l LogFormat, readonly bool, mmap bool) (err error) {
+	if !readonly {
+		if err := recoverConversion(filename); err != nil {
+			return err
+		}
+	}
+	segments, err := listSegments(filename)
+	if err != nil {
+		return err
+	}
+	if readonly {
+		// the last segment may be still being created or synced
+		for len(segments) > 1 {
+			stat, err := os.Stat(segmentFileName(filename, segments[len(segments)-1]))
+			if err == nil && stat.Size() >= HeaderSize {
+				break
+			}
+			segments = segments[:len(segments)-1]
+		}
+	}
+	if len(segments) == 0 {
+		if readonly {
+			return fmt.Errorf("invalid wal file")
+		}
+		segments = []int64{0}
+	}
+	segment := segments[len(segments)-1]
+
+	var f File
+	if mmap {
+		f, err = OpenMmapFile(segmentFileName(filename, segment))
+	} else {
+		f, err = OpenFile(segmentFileName(filename, segment), readonly)
+	}
 	if err != nil {
 		return err
 	}
@@ -126,41 +316,314 @@ func (w *Wal) Init(filename string, l LogFormat, readonly bool) (err error) {
 		}
 	}(f)
 
-	valid, err := l.IsValidFile(f)
+	has, err := hasHeader(f)
 	if err != nil {
 		return err
 	}
 	var header *FileHeader
-	if !valid {
+	if !has {
+		// newly created, or crashed while being created
 		if readonly {
 			return fmt.Errorf("invalid wal file")
 		}
-		id, err := GenUUID()
+		if l == nil {
+			l = defaultLogFormat()
+		}
+		header, err = newSegmentHeader(filename, l, segments, segment)
 		if err != nil {
 			return err
 		}
-		header = &FileHeader{Id: id, FileEnd: HeaderSize, EntryNum: 0}
 		err = l.WriteHeader(f, header)
 		if err != nil {
 			return err
 		}
 	} else {
-		header, err = l.ReadHeader(f)
+		detected, err := DetectLogFormat(f, l)
+		if err != nil {
+			// header is damaged, try to rebuild it with the given format
+			if readonly || l == nil {
+				return err
+			}
+			detected = l
+		}
+		l = detected
+
+		if readonly {
+			header, err = l.ReadHeader(f)
+		} else {
+			header, err = recoverSegment(filename, l, f, segments, segment)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
+	w.filename = filename
 	w.header = header
 	w.f = f
 	w.l = l
 	w.pos = header.FileEnd
 	w.broken = false
 	w.readonly = readonly
+	w.mmap = mmap
+	w.segment = segment
+	w.segments = segments
+	w.sealed = make(map[int64]*walSegment)
+	w.maxSegmentSize = DefaultSegmentSize
+	w.maxSegmentEntries = DefaultSegmentEntries
+	w.index = nil
+	w.indexFile = nil
+	w.clock = &HLC{}
+	w.lastStat = nil
+	if !readonly {
+		w.openIndex()
+	} else if stat, err := os.Stat(segmentFileName(filename, segment)); err == nil {
+		w.lastStat = stat
+	}
+	return nil
+}
+
+// Refresh catches up a readonly wal with its writer, the header of the last segment
+// is read again, the wal is reopened if a segment is created or the file is replaced.
+// it costs a few stats if nothing has changed, returns if anything has
+func (w *Wal) Refresh() (bool, error) {
+	if !w.readonly {
+		return false, fmt.Errorf("refresh writable wal")
+	}
+	stat, err := os.Stat(segmentFileName(w.filename, w.segment))
+	if err != nil {
+		return false, err
+	}
+	created := IsFile(segmentFileName(w.filename, w.segment+1))
+	if !created && w.lastStat != nil && os.SameFile(w.lastStat, stat) &&
+		stat.Size() == w.lastStat.Size() && stat.ModTime().Equal(w.lastStat.ModTime()) {
+		return false, nil
+	}
+	if created || w.lastStat == nil || !os.SameFile(w.lastStat, stat) {
+		return true, w.reopen()
+	}
+
+	header, err := w.l.ReadHeader(w.f)
+	if err != nil {
+		return false, err
+	}
+	w.lastStat = stat
+	if header.FileEnd == w.header.FileEnd && header.EntryNum == w.header.EntryNum &&
+		header.LastEntryId == w.header.LastEntryId {
+		return false, nil
+	}
+	w.header = header
+	w.pos = header.FileEnd
+	w.index = nil
+	return true, nil
+}
+
+// reopen opens a readonly wal again, keeping its settings
+func (w *Wal) reopen() error {
+	nw := Wal{}
+	if err := nw.init(w.filename, w.l, true, w.mmap); err != nil {
+		return err
+	}
+	nw.maxSegmentSize = w.maxSegmentSize
+	nw.maxSegmentEntries = w.maxSegmentEntries
+	nw.verifier = w.verifier
+	nw.owner = w.owner
+	nw.clock = w.clock
+	if err := w.Close(); err != nil {
+		logger.Error("close wal file[%v] failed[%v]", w.filename, err)
+	}
+	*w = nw
 	return nil
 }
 
+// openIndex prepares index of the last segment for appending,
+// without it lookups fall back to scanning the log
+func (w *Wal) openIndex() {
+	x, indexFile, err := openIndexFile(w.l, w.f, w.header)
+	if err != nil {
+		logger.Warn("open index of wal[%v] failed[%v]", w.f.Path(), err)
+		return
+	}
+	w.index = x
+	w.indexFile = indexFile
+}
+
+func (w *Wal) closeIndex() {
+	w.index = nil
+	if w.indexFile == nil {
+		return
+	}
+	if err := w.indexFile.Close(); err != nil {
+		logger.Error("close index file[%v] failed[%v]", w.indexFile.Name(), err)
+	}
+	w.indexFile = nil
+}
+
+// appendIndex is called after the entry at offset is committed
+func (w *Wal) appendIndex(offset int64, ops []*LogOperation) {
+	if w.index == nil {
+		return
+	}
+	record, ok := w.index.add(offset, ops)
+	if !ok || w.indexFile == nil {
+		return
+	}
+	_, err := w.indexFile.Write(encodeIndexRecords([]indexRecord{record}))
+	if err != nil {
+		// it will be rebuilt when the wal is opened next time
+		logger.Warn("append index of wal[%v] failed[%v]", w.f.Path(), err)
+		w.closeIndex()
+	}
+}
+
+// segmentIndex loads index of segment, it's rebuilt from the log if it's stale
+func (w *Wal) segmentIndex(segment int64) (*walIndex, error) {
+	if segment == w.segment {
+		if w.index == nil {
+			// an index being appended is not replaced, so as not to lose later records
+			x, err := loadIndex(w.l, w.f, w.header, !w.readonly && w.indexFile == nil)
+			if err != nil {
+				return nil, err
+			}
+			w.index = x
+		}
+		return w.index, nil
+	}
+
+	_, _, _, err := w.segmentFile(segment)
+	if err != nil {
+		return nil, err
+	}
+	s := w.sealed[segment]
+	if s.index == nil {
+		x, err := loadIndex(s.l, s.f, s.header, !w.readonly)
+		if err != nil {
+			return nil, err
+		}
+		s.index = x
+	}
+	return s.index, nil
+}
+
+// newSegmentHeader makes the header for an empty segment, which continues the
+// numbering of the segment before it
+func newSegmentHeader(filename string, l LogFormat, segments []int64, segment int64) (*FileHeader, error) {
+	prev := int64(-1)
+	for _, s := range segments {
+		if s < segment && s > prev {
+			prev = s
+		}
+	}
+	if prev < 0 {
+		id, err := GenUUID()
+		if err != nil {
+			return nil, err
+		}
+		return &FileHeader{Id: id, FileEnd: HeaderSize, EntryNum: 0, Segment: segment}, nil
+	}
+
+	f, err := OpenFile(segmentFileName(filename, prev), true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	prevFormat, err := DetectLogFormat(f, l)
+	if err != nil {
+		return nil, err
+	}
+	prevHeader, err := prevFormat.ReadHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &FileHeader{Id: prevHeader.Id, FileEnd: HeaderSize,
+		LastEntryId: prevHeader.LastEntryId, EntryNum: prevHeader.EntryNum,
+		Segment: segment, StartNum: prevHeader.EntryNum}, nil
+}
+
+// recoverSegment repairs the last segment after a crash, entries written
+// without the header being updated are adopted, a torn tail is truncated
+// and a damaged or stale header is rebuilt from the entries
+func recoverSegment(filename string, l LogFormat, f File, segments []int64, segment int64) (*FileHeader, error) {
+	fileSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	rebuild := false
+	header, err := l.ReadHeader(f)
+	if err != nil {
+		logger.Warn("header of wal[%v] is damaged[%v], rebuild it", f.Path(), err)
+		rebuild = true
+	} else if header.FileEnd < HeaderSize || header.FileEnd > fileSize {
+		logger.Warn("header of wal[%v] is stale, file end[%v] file size[%v], rebuild it",
+			f.Path(), header.FileEnd, fileSize)
+		rebuild = true
+	}
+	if rebuild {
+		header, err = newSegmentHeader(filename, l, segments, segment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newHeader := gogoproto.Clone(header).(*FileHeader)
+	adopted := 0
+	for newHeader.FileEnd < fileSize {
+		entry := LogEntry{}
+		readSz, err := l.ReadEntry(f, newHeader.FileEnd, &entry)
+		if err != nil || readSz <= 0 || newHeader.FileEnd+readSz > fileSize || len(entry.Ops) == 0 {
+			break
+		}
+
+		first := entry.Ops[0]
+		last := entry.Ops[len(entry.Ops)-1]
+		if rebuild && adopted == 0 && first.Num > 0 {
+			newHeader.StartNum = first.Num - 1
+		}
+		if last.Num > 0 {
+			newHeader.EntryNum = last.Num
+		} else {
+			newHeader.EntryNum += int64(len(entry.Ops))
+		}
+		newHeader.LastEntryId = last.Gid
+		newHeader.FileEnd += readSz
+		adopted++
+	}
+
+	if rebuild && adopted == 0 && fileSize > HeaderSize {
+		return nil, fmt.Errorf("header of wal[%v] is damaged and no entry is recoverable", f.Path())
+	}
+	repaired := false
+	if newHeader.FileEnd < fileSize {
+		logger.Warn("truncate torn tail of wal[%v] from %v to %v", f.Path(), fileSize, newHeader.FileEnd)
+		if err := f.Truncate(newHeader.FileEnd); err != nil {
+			return nil, err
+		}
+		repaired = true
+	}
+	if rebuild || adopted > 0 {
+		logger.Warn("adopt %v entries not recorded in header of wal[%v]", adopted, f.Path())
+		if err := l.WriteHeader(f, newHeader); err != nil {
+			return nil, err
+		}
+		repaired = true
+	}
+	if repaired {
+		if err := f.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return newHeader, nil
+}
+
 func (w *Wal) Close() error {
+	w.closeIndex()
+	for segment, s := range w.sealed {
+		if err := s.f.Close(); err != nil {
+			logger.Error("close wal file[%v] failed[%v]", s.f.Path(), err)
+		}
+		delete(w.sealed, segment)
+	}
 	if w.f == nil {
 		return nil
 	}
@@ -173,13 +636,198 @@ func (w *Wal) Close() error {
 	return nil
 }
 
+// SetSegmentLimit sets the size in bytes and the number of operations
+// past which a segment is closed, <= 0 means no limit
+func (w *Wal) SetSegmentLimit(size int64, entries int64) {
+	w.maxSegmentSize = size
+	w.maxSegmentEntries = entries
+}
+
+func (w *Wal) SetSigner(key ed25519.PrivateKey) {
+	w.signer = key
+}
+
+// SetClock sets the clock stamping appended operations, a clock of its own is used
+// by default, which doesn't know timestamps made before the wal is opened
+func (w *Wal) SetClock(c *HLC) {
+	w.clock = c
+}
+
+// SetVerifier makes iterators stop at entries not signed by key, or having
+// operations not made by owner or not numbered in order
+func (w *Wal) SetVerifier(owner string, key ed25519.PublicKey) {
+	w.owner = owner
+	w.verifier = key
+}
+
+// Format is the format of the last segment, which new entries are written in
+func (w *Wal) Format() LogFormat {
+	return w.l
+}
+
+// Offset is the end of the last segment
 func (w *Wal) Offset() int64 {
 	return w.header.FileEnd
 }
 
+// Segment is the index of the last segment
+func (w *Wal) Segment() int64 {
+	return w.segment
+}
+
+// EntryNum is the number of operations ever appended, also the .Num of the last one
+func (w *Wal) EntryNum() int64 {
+	return w.header.EntryNum
+}
+
+func (w *Wal) firstSegment() int64 {
+	if len(w.segments) == 0 {
+		return w.segment
+	}
+	return w.segments[0]
+}
+
+func (w *Wal) nextSegment(segment int64) (int64, bool) {
+	for _, s := range w.segments {
+		if s > segment {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// segmentFile returns the file of segment, its format and where its entries end
+// startNum is num before the first operation of an opened segment
+func (w *Wal) startNum(segment int64) int64 {
+	if segment == w.segment {
+		return w.header.StartNum
+	}
+	if s, ok := w.sealed[segment]; ok {
+		return s.header.StartNum
+	}
+	return 0
+}
+
+func (w *Wal) segmentFile(segment int64) (File, LogFormat, int64, error) {
+	if segment == w.segment {
+		return w.f, w.l, w.header.FileEnd, nil
+	}
+	if s, ok := w.sealed[segment]; ok {
+		return s.f, s.l, s.header.FileEnd, nil
+	}
+
+	filename := segmentFileName(w.filename, segment)
+	if !IsFile(filename) {
+		return nil, nil, 0, fmt.Errorf("segment[%v] not exist", segment)
+	}
+	var f File
+	var err error
+	if w.mmap {
+		f, err = OpenMmapFile(filename)
+	} else {
+		f, err = OpenFile(filename, true)
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	l, err := DetectLogFormat(f, w.l)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, 0, err
+	}
+	header, err := l.ReadHeader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, 0, err
+	}
+	w.sealed[segment] = &walSegment{f: f, l: l, header: header}
+	return f, l, header.FileEnd, nil
+}
+
+func (w *Wal) shouldRollover() bool {
+	if w.header.FileEnd <= HeaderSize {
+		return false
+	}
+	if w.maxSegmentSize > 0 && w.header.FileEnd >= w.maxSegmentSize {
+		return true
+	}
+	if w.maxSegmentEntries > 0 && w.header.EntryNum-w.header.StartNum >= w.maxSegmentEntries {
+		return true
+	}
+	return false
+}
+
+// rollover seals the last segment and starts a new one
+func (w *Wal) rollover() error {
+	if err := w.f.Flush(); err != nil {
+		return err
+	}
+
+	segment := w.segment + 1
+	f, err := OpenFile(segmentFileName(w.filename, segment), false)
+	if err != nil {
+		return err
+	}
+	header := &FileHeader{Id: w.header.Id, FileEnd: HeaderSize,
+		LastEntryId: w.header.LastEntryId, EntryNum: w.header.EntryNum,
+		Segment: segment, StartNum: w.header.EntryNum}
+	err = w.l.WriteHeader(f, header)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := w.f.Close(); err != nil {
+		logger.Error("close wal file[%v] failed[%v]", w.f.Path(), err)
+	}
+	w.closeIndex()
+	w.f = f
+	w.header = header
+	w.pos = HeaderSize
+	w.segment = segment
+	w.segments = append(w.segments, segment)
+	w.openIndex()
+	return nil
+}
+
+// Trim removes segments before segment, the last segment is always kept
+// returns the number of segments removed
+func (w *Wal) Trim(segment int64) (int, error) {
+	if w.broken {
+		return 0, fmt.Errorf("wal is broken")
+	}
+	if w.readonly {
+		return 0, fmt.Errorf("trim readonly file")
+	}
+
+	removed := 0
+	for len(w.segments) > 0 {
+		s := w.segments[0]
+		if s >= segment || s == w.segment {
+			break
+		}
+		if sealed, ok := w.sealed[s]; ok {
+			if err := sealed.f.Close(); err != nil {
+				logger.Error("close wal file[%v] failed[%v]", sealed.f.Path(), err)
+			}
+			delete(w.sealed, s)
+		}
+		// remove from the oldest, so what remains is always continuous
+		if err := os.Remove(segmentFileName(w.filename, s)); err != nil {
+			return removed, err
+		}
+		if err := os.Remove(indexFileName(segmentFileName(w.filename, s))); err != nil && !os.IsNotExist(err) {
+			logger.Warn("remove index of segment[%v] failed[%v]", s, err)
+		}
+		w.segments = w.segments[1:]
+		removed++
+	}
+	return removed, nil
+}
+
 // Append multiple operations will be appended as a single log entry
 // returns the gid of the last operation
-// generate and populate .Num, .Gid for each operation
+// generate and populate .Num, .Gid, .Hlc for each operation
 func (w *Wal) Append(logOp ...*LogOperation) (string, int64, error) {
 	if w.broken {
 		return "", 0, fmt.Errorf("wal is broken")
@@ -203,6 +851,7 @@ func (w *Wal) Append(logOp ...*LogOperation) (string, int64, error) {
 	for i := range logOp {
 		logOp[i].Gid = gids[i]
 		logOp[i].Num = w.header.EntryNum + int64(i) + 1
+		logOp[i].Hlc = int64(w.clock.Now())
 	}
 	lastGid := gids[len(gids)-1]
 
@@ -225,7 +874,19 @@ func (w *Wal) AppendRaw(logOp ...*LogOperation) error {
 		return fmt.Errorf("empty input")
 	}
 
-	writeSz, err := w.l.AppendEntry(w.f, w.pos, &LogEntry{Ops: logOp})
+	if w.shouldRollover() {
+		if err := w.rollover(); err != nil {
+			return err
+		}
+	}
+
+	entry := LogEntry{Ops: logOp}
+	if w.signer != nil {
+		if err := signEntry(w.signer, &entry); err != nil {
+			return err
+		}
+	}
+	writeSz, err := w.l.AppendEntry(w.f, w.pos, &entry)
 	if err != nil {
 		return err
 	}
@@ -244,6 +905,7 @@ func (w *Wal) AppendRaw(logOp ...*LogOperation) error {
 		return err
 	}
 
+	w.appendIndex(w.pos, logOp)
 	w.pos = newPos
 	w.header = newHeader
 	return nil
@@ -266,33 +928,159 @@ func (w *Wal) Iterator() *WalIterator {
 	return &i
 }
 
-func (w *Wal) IteratorOffset(offset int64) *WalIterator {
+// IteratorAt iterates from offset of segment, as recorded in LogProgress
+func (w *Wal) IteratorAt(segment int64, offset int64) (*WalIterator, error) {
+	if segment < w.firstSegment() {
+		return nil, fmt.Errorf("segment[%v] of wal[%v] has been trimmed", segment, w.filename)
+	}
 	i := WalIterator{}
-	i.InitWithOffset(w, offset)
-	return &i
+	i.InitAt(w, segment, offset)
+	return &i, nil
 }
 
-func (w *Wal) IteratorFrom(start string, inclusive bool) (*WalIterator, error) {
-	i := WalIterator{}
-	i.Init(w)
-	if len(start) == 0 {
-		return &i, nil
+// IteratorAfter resumes after operation num, which is recorded in LogProgress with
+// segment and offset of the entry after it. the offset is checked against the log,
+// as it's changed when the wal is converted to another format, num is looked up if
+// it doesn't match
+func (w *Wal) IteratorAfter(segment int64, offset int64, num int64) (*WalIterator, error) {
+	if num <= 0 || w.offsetAfter(segment, offset, num) {
+		return w.IteratorAt(segment, offset)
+	}
+	logger.Warn("offset[%v] of segment[%v] of wal[%v] mismatches num[%v], look it up", offset, segment, w.filename, num)
+	if num >= w.header.EntryNum {
+		return w.IteratorAt(w.segment, w.header.FileEnd)
 	}
+	return w.IteratorAtNum(num + 1)
+}
 
-	found := false
+// offsetAfter tells if offset of segment is where operations after num start
+func (w *Wal) offsetAfter(segment int64, offset int64, num int64) bool {
+	if segment < w.firstSegment() || offset < HeaderSize {
+		return false
+	}
+	f, l, endPos, err := w.segmentFile(segment)
+	if err != nil {
+		return false
+	}
+	if offset == endPos {
+		header := w.header
+		if segment != w.segment {
+			header = w.sealed[segment].header
+		}
+		return header.EntryNum >= num
+	}
+	if offset > endPos {
+		return false
+	}
+	entry := LogEntry{}
+	readSz, err := l.ReadEntry(f, offset, &entry)
+	if err != nil || readSz <= 0 || len(entry.Ops) == 0 {
+		return false
+	}
+	// progress stopped within an entry is recorded with the offset after it
+	return entry.Ops[0].Num > num
+}
+
+// IteratorAtNum iterates from the operation numbered num
+func (w *Wal) IteratorAtNum(num int64) (*WalIterator, error) {
+	segment, ok := w.segmentOfNum(num)
+	if !ok {
+		return nil, fmt.Errorf("num[%v] not found in wal[%v]", num, w.filename)
+	}
+	offset := int64(HeaderSize)
+	x, err := w.segmentIndex(segment)
+	if err != nil {
+		logger.Warn("load index of segment[%v] of wal[%v] failed[%v]", segment, w.filename, err)
+	} else if pos, ok := x.findNum(num); ok {
+		offset = pos
+	}
+
+	i := WalIterator{}
+	i.InitAt(w, segment, offset)
 	for i.Next() {
-		if i.LogOp().Gid == start {
-			found = true
+		if i.LogOp().Num == num {
+			i.index -= 1
+			return &i, nil
+		}
+		if i.LogOp().Num > num {
 			break
 		}
 	}
-	if !found {
-		return nil, fmt.Errorf("start position not found")
+	return nil, fmt.Errorf("num[%v] not found in wal[%v]", num, w.filename)
+}
+
+// segmentOfNum finds the segment containing operation num by headers
+func (w *Wal) segmentOfNum(num int64) (int64, bool) {
+	for idx := len(w.segments) - 1; idx >= 0; idx-- {
+		segment := w.segments[idx]
+		header := w.header
+		if segment != w.segment {
+			_, _, _, err := w.segmentFile(segment)
+			if err != nil {
+				logger.Warn("open segment[%v] of wal[%v] failed[%v]", segment, w.filename, err)
+				return 0, false
+			}
+			header = w.sealed[segment].header
+		}
+		if num > header.EntryNum {
+			return 0, false
+		}
+		if num > header.StartNum {
+			return segment, true
+		}
+	}
+	return 0, false
+}
+
+// seekGid returns an iterator whose current operation is gid, found by indexes,
+// false if some index is unavailable and the log has to be scanned
+func (w *Wal) seekGid(gid string) (*WalIterator, bool, error) {
+	for idx := len(w.segments) - 1; idx >= 0; idx-- {
+		segment := w.segments[idx]
+		x, err := w.segmentIndex(segment)
+		if err != nil {
+			logger.Warn("load index of segment[%v] of wal[%v] failed[%v]", segment, w.filename, err)
+			return nil, false, nil
+		}
+		for _, r := range x.findGid(gid) {
+			i := WalIterator{}
+			i.InitAt(w, segment, r.offset)
+			for i.Next() && i.Segment() == segment && i.LogOp().Num < r.end {
+				if i.LogOp().Gid == gid {
+					return &i, true, nil
+				}
+			}
+		}
+	}
+	return nil, true, fmt.Errorf("start position not found")
+}
+
+func (w *Wal) IteratorFrom(start string, inclusive bool) (*WalIterator, error) {
+	if len(start) == 0 {
+		return w.Iterator(), nil
+	}
+
+	i, indexed, err := w.seekGid(start)
+	if err != nil {
+		return nil, err
+	}
+	if !indexed {
+		i = w.Iterator()
+		found := false
+		for i.Next() {
+			if i.LogOp().Gid == start {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("start position not found")
+		}
 	}
 	if inclusive {
 		i.index -= 1
 	}
-	return &i, nil
+	return i, nil
 }
 
 func (w *Wal) RangeIterator(start string, end string, includeStart bool, includeEnd bool) (*WalIterator, error) {
@@ -1,46 +1,210 @@
 package storage
 
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type SyncMode int
+
+const (
+	// fsync after every write
+	SyncAlways SyncMode = iota
+	// fsync after every Count writes
+	SyncEveryN
+	// fsync at most Interval after a write, writes in between share one fsync
+	SyncPeriodic
+	// left to the OS, fsync only on Sync and Close
+	SyncNever
+)
+
+const DefaultSyncPeriod = 10 * time.Millisecond
+
+// ErrNotDurable means a write is done but fsync after it failed, so it may be lost
+// by a crash. it must not be retried, or it's written twice
+var ErrNotDurable = errors.New("written but not durable")
+
+// notDurableError wraps the fsync error, it matches ErrNotDurable
+type notDurableError struct {
+	err error
+}
+
+func (e *notDurableError) Error() string {
+	return fmt.Sprintf("%v, sync wal failed[%v]", ErrNotDurable, e.err)
+}
+
+func (e *notDurableError) Unwrap() error {
+	return e.err
+}
+
+func (e *notDurableError) Is(target error) bool {
+	return target == ErrNotDurable
+}
+
+var syncModeNames = map[SyncMode]string{
+	SyncAlways:   "always",
+	SyncEveryN:   "every_n",
+	SyncPeriodic: "periodic",
+	SyncNever:    "never",
+}
+
+func (m SyncMode) String() string {
+	if name, ok := syncModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	for mode, name := range syncModeNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync mode[%v]", s)
+}
+
+// DurabilityPolicy decides when writes to wal are fsynced,
+// the zero value fsyncs every write
+type DurabilityPolicy struct {
+	Mode SyncMode
+	// writes per fsync of SyncEveryN, <= 0 means 1
+	Count int
+	// max delay of fsync of SyncPeriodic, <= 0 means DefaultSyncPeriod
+	Interval time.Duration
+}
+
+// WalHelper keeps the wal open between writes, and fsyncs it following the policy
 type WalHelper struct {
-	w          *Wal
-	filename   string
-	l          LogFormat
-	writeCount int
-	count      int
+	mu       sync.Mutex
+	w        *Wal
+	filename string
+	l        LogFormat
+	policy   DurabilityPolicy
+	pending  int         // writes not fsynced yet
+	timer    *time.Timer // fsync scheduled by SyncPeriodic
+
+	maxSegmentSize    int64
+	maxSegmentEntries int64
+
+	signer ed25519.PrivateKey
+	clock  *HLC
 }
 
-func (w *WalHelper) Init(filename string, l LogFormat, writeCount int) {
+func (w *WalHelper) Init(filename string, l LogFormat, policy DurabilityPolicy) {
+	if policy.Count <= 0 {
+		policy.Count = 1
+	}
+	if policy.Interval <= 0 {
+		policy.Interval = DefaultSyncPeriod
+	}
 	w.filename = filename
 	w.l = l
-	w.writeCount = writeCount
-	w.count = 0
+	w.policy = policy
+	w.pending = 0
+	w.timer = nil
+	w.maxSegmentSize = DefaultSegmentSize
+	w.maxSegmentEntries = DefaultSegmentEntries
+}
+
+func (w *WalHelper) SetSegmentLimit(size int64, entries int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.maxSegmentSize = size
+	w.maxSegmentEntries = entries
+	if w.w != nil {
+		w.w.SetSegmentLimit(size, entries)
+	}
 }
 
+func (w *WalHelper) SetSigner(key ed25519.PrivateKey) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.signer = key
+	if w.w != nil {
+		w.w.SetSigner(key)
+	}
+}
+
+func (w *WalHelper) SetClock(c *HLC) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clock = c
+	if w.w != nil {
+		w.w.SetClock(c)
+	}
+}
+
+// Close fsyncs pending writes and closes the wal
 func (w *WalHelper) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.sync(); err != nil {
+		logger.Error("sync wal failed[%v]", err)
+	}
 	if w.w != nil {
 		err := w.w.Close()
 		if err != nil {
 			logger.Error("close wal failed[%v]", err)
-			w.w = nil
 		}
 		w.w = nil
 	}
 }
 
-func (w *WalHelper) check() {
-	if w.count >= w.writeCount {
-		if w.w != nil {
-			if err := w.w.Flush(); err != nil {
-				logger.Error("flush wal failed[%v]", err)
-				return
-			}
-			if err := w.w.Close(); err != nil {
-				logger.Error("close wal failed[%v]", err)
-				return
+// Sync fsyncs pending writes
+func (w *WalHelper) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+func (w *WalHelper) sync() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.w == nil || w.pending == 0 {
+		return nil
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	w.pending = 0
+	return nil
+}
+
+func (w *WalHelper) onTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if err := w.sync(); err != nil {
+		logger.Error("sync wal failed[%v]", err)
+	}
+}
+
+// check fsyncs following the policy after a write, failures of SyncAlways and SyncEveryN
+// are returned to the write as ErrNotDurable, those of SyncPeriodic are only logged by the timer
+func (w *WalHelper) check() error {
+	switch w.policy.Mode {
+	case SyncAlways:
+		if err := w.sync(); err != nil {
+			return &notDurableError{err: err}
+		}
+	case SyncEveryN:
+		if w.pending >= w.policy.Count {
+			if err := w.sync(); err != nil {
+				return &notDurableError{err: err}
 			}
-			w.w = nil
 		}
-		w.count = 0
+	case SyncPeriodic:
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.policy.Interval, w.onTimer)
+		}
 	}
+	return nil
 }
 
 func (w *WalHelper) getW() (*Wal, error) {
@@ -50,13 +214,21 @@ func (w *WalHelper) getW() (*Wal, error) {
 		if err != nil {
 			return nil, err
 		}
+		wal.SetSegmentLimit(w.maxSegmentSize, w.maxSegmentEntries)
+		wal.SetSigner(w.signer)
+		if w.clock != nil {
+			wal.SetClock(w.clock)
+		}
 		w.w = &wal
 		return w.w, nil
 	}
 	return w.w, nil
 }
 
+// Append returns gid and num of the entry with ErrNotDurable if it's written but not fsynced
 func (w *WalHelper) Append(logOp ...*LogOperation) (string, int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	wal, err := w.getW()
 	if err != nil {
 		return "", 0, err
@@ -66,16 +238,42 @@ func (w *WalHelper) Append(logOp ...*LogOperation) (string, int64, error) {
 		return "", 0, err
 	}
 
-	w.count++
-	w.check()
+	w.pending++
+	// written but may be lost, it's synced again by the next write
+	if err := w.check(); err != nil {
+		return gid, num, err
+	}
 
 	return gid, num, nil
 }
 
-func (w *WalHelper) Offset() (int64, error) {
+func (w *WalHelper) EntryNum() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wal, err := w.getW()
+	if err != nil {
+		return 0, err
+	}
+	return wal.EntryNum(), nil
+}
+
+func (w *WalHelper) Trim(segment int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	wal, err := w.getW()
 	if err != nil {
 		return 0, err
 	}
-	return wal.Offset(), nil
+	return wal.Trim(segment)
+}
+
+// Format is the format of the wal, detected when it's opened
+func (w *WalHelper) Format() (LogFormat, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wal, err := w.getW()
+	if err != nil {
+		return nil, err
+	}
+	return wal.Format(), nil
 }
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func appendTestOps(t assert.TestingT, w *WalHelper, n int) {
+	for i := 0; i < n; i++ {
+		_, _, err := w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(i)})
+		assert.Nil(t, err)
+	}
+}
+
+func TestWalHelperSyncModes(t *testing.T) {
+	t.Cleanup(delWalFile)
+
+	w := WalHelper{}
+	w.Init(getWalFile(), nil, DurabilityPolicy{})
+	appendTestOps(t, &w, 2)
+	assert.Equal(t, 0, w.pending)
+	// kept open between writes
+	wal := w.w
+	appendTestOps(t, &w, 1)
+	assert.True(t, wal == w.w)
+	w.Close()
+
+	w = WalHelper{}
+	w.Init(getWalFile(), nil, DurabilityPolicy{Mode: SyncEveryN, Count: 3})
+	appendTestOps(t, &w, 2)
+	assert.Equal(t, 2, w.pending)
+	appendTestOps(t, &w, 1)
+	assert.Equal(t, 0, w.pending)
+	w.Close()
+
+	w = WalHelper{}
+	w.Init(getWalFile(), nil, DurabilityPolicy{Mode: SyncNever})
+	appendTestOps(t, &w, 5)
+	assert.Equal(t, 5, w.pending)
+	err := w.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, w.pending)
+	w.Close()
+
+	w = WalHelper{}
+	w.Init(getWalFile(), nil, DurabilityPolicy{Mode: SyncPeriodic, Interval: 20 * time.Millisecond})
+	appendTestOps(t, &w, 5)
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.pending == 0
+	}, time.Second, 5*time.Millisecond)
+	appendTestOps(t, &w, 1)
+	w.Close()
+	assert.Equal(t, 0, w.pending)
+	assert.Nil(t, w.timer)
+
+	assert.Equal(t, 6, countWalEntries(t, nil))
+}
+
+func TestParseSyncMode(t *testing.T) {
+	for _, mode := range []SyncMode{SyncAlways, SyncEveryN, SyncPeriodic, SyncNever} {
+		parsed, err := ParseSyncMode(mode.String())
+		assert.Nil(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+	_, err := ParseSyncMode("sometimes")
+	assert.NotNil(t, err)
+}
+
+type flushFailingFile struct {
+	File
+}
+
+func (f *flushFailingFile) Flush() error {
+	return fmt.Errorf("flush failed")
+}
+
+func TestWalHelperSyncFailure(t *testing.T) {
+	t.Cleanup(delWalFile)
+
+	for _, policy := range []DurabilityPolicy{{}, {Mode: SyncEveryN, Count: 2}} {
+		delWalFile()
+		w := WalHelper{}
+		w.Init(getWalFile(), nil, policy)
+		appendTestOps(t, &w, 1)
+		f := w.w.f
+		// synced by the next write in both
+		w.w.f = &flushFailingFile{File: f}
+		gid, num, err := w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "failed"})
+		assert.True(t, errors.Is(err, ErrNotDurable))
+		assert.Equal(t, "flush failed", errors.Unwrap(err).Error())
+		// written anyway
+		assert.NotEmpty(t, gid)
+		assert.Equal(t, int64(2), num)
+		assert.Greater(t, w.pending, 0)
+		w.w.f = f
+		w.Close()
+	}
+}
@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// sidecar index of a segment, <segment file>.idx, made of records, one for every
+// indexInterval entries, written when the last of them is appended:
+// num of the first operation(8) + offset of the first entry(8) + number of gids(4) +
+// crc32 of the record(4) + sorted hashes of gids of the block(8 each).
+// lookups of a num find the nearest record and scan entries forward from it, lookups
+// of a gid search hashes of all records and scan the blocks found.
+// it's only a cache, whenever it doesn't match the segment, it's rebuilt from the log
+const indexRecordHeaderSize = 24
+
+// entries per index record
+const indexInterval = 16
+
+const indexFileExt = ".idx"
+
+func gidKey(gid string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(gid))
+	return h.Sum64()
+}
+
+type indexRecord struct {
+	num    int64
+	offset int64
+	gids   []uint64 // sorted, only kept till the record is encoded and added
+}
+
+// gidBlock tells an operation hashed to key is in the block of record
+type gidBlock struct {
+	key    uint64
+	record int
+}
+
+func indexFileName(segmentFile string) string {
+	return segmentFile + indexFileExt
+}
+
+func (r *indexRecord) size() int {
+	return indexRecordHeaderSize + 8*len(r.gids)
+}
+
+func (r *indexRecord) encode(buf []byte) {
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(r.num))
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(r.offset))
+	binary.LittleEndian.PutUint32(buf[16:20], uint32(len(r.gids)))
+	for i, key := range r.gids {
+		binary.LittleEndian.PutUint64(buf[indexRecordHeaderSize+8*i:], key)
+	}
+	binary.LittleEndian.PutUint32(buf[20:24], indexRecordChecksum(buf[:r.size()]))
+}
+
+// decode returns size of the record at the beginning of buf, false if it's damaged
+func (r *indexRecord) decode(buf []byte) (int, bool) {
+	if len(buf) < indexRecordHeaderSize {
+		return 0, false
+	}
+	n := int(binary.LittleEndian.Uint32(buf[16:20]))
+	if n > (len(buf)-indexRecordHeaderSize)/8 {
+		return 0, false
+	}
+	size := indexRecordHeaderSize + 8*n
+	if binary.LittleEndian.Uint32(buf[20:24]) != indexRecordChecksum(buf[:size]) {
+		return 0, false
+	}
+	r.num = int64(binary.LittleEndian.Uint64(buf[0:8]))
+	r.offset = int64(binary.LittleEndian.Uint64(buf[8:16]))
+	r.gids = make([]uint64, n)
+	for i := range r.gids {
+		r.gids[i] = binary.LittleEndian.Uint64(buf[indexRecordHeaderSize+8*i:])
+	}
+	return size, true
+}
+
+// indexRecordChecksum sums an encoded record except the checksum itself
+func indexRecordChecksum(buf []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(buf[:20])
+	h.Write(buf[indexRecordHeaderSize:])
+	return h.Sum32()
+}
+
+func encodeIndexRecords(records []indexRecord) []byte {
+	size := 0
+	for i := range records {
+		size += records[i].size()
+	}
+	data := make([]byte, size)
+	pos := 0
+	for i := range records {
+		records[i].encode(data[pos:])
+		pos += records[i].size()
+	}
+	return data
+}
+
+type walIndex struct {
+	records []indexRecord // ascending by num, without gids
+	// gids of records, ascending by key and record
+	keys []gidBlock
+	// added after keys are sorted last time, they're merged into keys on lookups
+	pending []gidBlock
+	// entries appended after the last record, recorded when there are indexInterval of them
+	tail        indexRecord
+	tailEntries int
+	lastNum     int64 // of the last entry added
+}
+
+// add records the entry at offset, the record is returned if a block is completed by it
+func (x *walIndex) add(offset int64, ops []*LogOperation) (indexRecord, bool) {
+	if len(ops) == 0 {
+		return indexRecord{}, false
+	}
+	if x.tailEntries == 0 {
+		x.tail = indexRecord{num: ops[0].Num, offset: offset}
+	}
+	for _, op := range ops {
+		x.tail.gids = append(x.tail.gids, gidKey(op.Gid))
+	}
+	x.tailEntries++
+	x.lastNum = ops[len(ops)-1].Num
+	if x.tailEntries < indexInterval {
+		return indexRecord{}, false
+	}
+	r := x.tail
+	sort.Slice(r.gids, func(i, j int) bool { return r.gids[i] < r.gids[j] })
+	x.addRecord(r)
+	x.tail = indexRecord{}
+	x.tailEntries = 0
+	return r, true
+}
+
+func (x *walIndex) addRecord(r indexRecord) {
+	for _, key := range r.gids {
+		x.pending = append(x.pending, gidBlock{key: key, record: len(x.records)})
+	}
+	x.records = append(x.records, indexRecord{num: r.num, offset: r.offset})
+}
+
+// sortKeys merges pending keys into keys, it costs a sort of all keys only once when
+// the index is loaded, and a merge after blocks are appended
+func (x *walIndex) sortKeys() {
+	if len(x.pending) == 0 {
+		return
+	}
+	less := func(a, b gidBlock) bool {
+		return a.key < b.key || (a.key == b.key && a.record < b.record)
+	}
+	sort.Slice(x.pending, func(i, j int) bool { return less(x.pending[i], x.pending[j]) })
+	if len(x.keys) == 0 {
+		x.keys = x.pending
+		x.pending = nil
+		return
+	}
+	merged := make([]gidBlock, 0, len(x.keys)+len(x.pending))
+	i, j := 0, 0
+	for i < len(x.keys) && j < len(x.pending) {
+		if less(x.pending[j], x.keys[i]) {
+			merged = append(merged, x.pending[j])
+			j++
+		} else {
+			merged = append(merged, x.keys[i])
+			i++
+		}
+	}
+	merged = append(merged, x.keys[i:]...)
+	merged = append(merged, x.pending[j:]...)
+	x.keys = merged
+	x.pending = nil
+}
+
+// encoded returns records with their gids
+func (x *walIndex) encoded() []indexRecord {
+	x.sortKeys()
+	records := make([]indexRecord, len(x.records))
+	copy(records, x.records)
+	for _, k := range x.keys {
+		records[k.record].gids = append(records[k.record].gids, k.key)
+	}
+	return records
+}
+
+// findNum returns offset of the nearest entry recorded at or before operation num
+func (x *walIndex) findNum(num int64) (int64, bool) {
+	if x.tailEntries > 0 && x.tail.num <= num {
+		return x.tail.offset, true
+	}
+	i := sort.Search(len(x.records), func(i int) bool { return x.records[i].num > num })
+	if i == 0 {
+		return 0, false
+	}
+	return x.records[i-1].offset, true
+}
+
+// gidRange is a block of entries which may contain a gid, from offset till operation end
+type gidRange struct {
+	offset int64
+	end    int64
+}
+
+// findGid returns blocks which may contain operation gid, the latest first
+func (x *walIndex) findGid(gid string) []gidRange {
+	key := gidKey(gid)
+	ranges := []gidRange{}
+	if x.tailEntries > 0 {
+		for _, k := range x.tail.gids {
+			if k == key {
+				ranges = append(ranges, gidRange{offset: x.tail.offset, end: math.MaxInt64})
+				break
+			}
+		}
+	}
+	x.sortKeys()
+	i := sort.Search(len(x.keys), func(i int) bool { return x.keys[i].key >= key })
+	j := i
+	for j < len(x.keys) && x.keys[j].key == key {
+		j++
+	}
+	for ; j > i; j-- {
+		record := x.keys[j-1].record
+		end := int64(math.MaxInt64)
+		if record+1 < len(x.records) {
+			end = x.records[record+1].num
+		} else if x.tailEntries > 0 {
+			end = x.tail.num
+		}
+		ranges = append(ranges, gidRange{offset: x.records[record].offset, end: end})
+	}
+	return ranges
+}
+
+// scanIndex adds entries from pos to the end of the segment to x
+func scanIndex(l LogFormat, f File, header *FileHeader, pos int64, x *walIndex) error {
+	for pos < header.FileEnd {
+		entry := LogEntry{}
+		readSz, err := l.ReadEntry(f, pos, &entry)
+		if err != nil {
+			return err
+		}
+		if readSz <= 0 {
+			return fmt.Errorf("read size unexpected")
+		}
+		x.add(pos, entry.Ops)
+		pos += readSz
+	}
+	return nil
+}
+
+// matches tells if entries added end where header says
+func (x *walIndex) matches(header *FileHeader) bool {
+	if x.lastNum == 0 {
+		return header.EntryNum == header.StartNum
+	}
+	return x.lastNum == header.EntryNum
+}
+
+// buildIndex reads all entries of a segment
+func buildIndex(l LogFormat, f File, header *FileHeader) (*walIndex, error) {
+	x := walIndex{}
+	err := scanIndex(l, f, header, HeaderSize, &x)
+	if err != nil {
+		return nil, err
+	}
+	if !x.matches(header) {
+		return nil, fmt.Errorf("entries mismatch header of wal[%v]", f.Path())
+	}
+	return &x, nil
+}
+
+// matchIndex makes the index of records read from file, nil if they don't match the
+// segment. every record has to be where an entry starting with its num is, entries of
+// the last record and after it are read again, it matches if the last record is
+// reproduced and no more records are missing
+func matchIndex(l LogFormat, f File, header *FileHeader, records []indexRecord) *walIndex {
+	x := walIndex{}
+	pos := int64(HeaderSize)
+	if len(records) > 0 {
+		if records[0].num != header.StartNum+1 || records[0].offset != HeaderSize {
+			return nil
+		}
+		for i := 1; i < len(records); i++ {
+			if records[i].num <= records[i-1].num || records[i].offset <= records[i-1].offset {
+				return nil
+			}
+		}
+		last := records[len(records)-1]
+		if last.offset >= header.FileEnd {
+			return nil
+		}
+		for _, r := range records[:len(records)-1] {
+			entry := LogEntry{}
+			_, err := l.ReadEntry(f, r.offset, &entry)
+			if err != nil || len(entry.Ops) == 0 || entry.Ops[0].Num != r.num {
+				return nil
+			}
+			x.addRecord(r)
+		}
+		pos = last.offset
+	}
+	scanned := walIndex{records: x.records[:len(x.records):len(x.records)]}
+	if err := scanIndex(l, f, header, pos, &scanned); err != nil {
+		return nil
+	}
+	if len(scanned.records) != len(records) || !scanned.matches(header) {
+		return nil
+	}
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		r := scanned.records[len(records)-1]
+		if r.num != last.num || r.offset != last.offset || !equalKeys(scanned.pending, last.gids) {
+			return nil
+		}
+		x.addRecord(last)
+	}
+	x.tail = scanned.tail
+	x.tailEntries = scanned.tailEntries
+	x.lastNum = scanned.lastNum
+	return &x
+}
+
+// equalKeys tells if keys of a block are gids
+func equalKeys(keys []gidBlock, gids []uint64) bool {
+	if len(keys) != len(gids) {
+		return false
+	}
+	sorted := make([]uint64, len(keys))
+	for i, k := range keys {
+		sorted[i] = k.key
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i := range sorted {
+		if sorted[i] != gids[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readIndexFile returns records in filename, nil if it doesn't exist or it's damaged
+func readIndexFile(filename string) ([]indexRecord, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	records := []indexRecord{}
+	for pos := 0; pos < len(data); {
+		r := indexRecord{}
+		size, ok := r.decode(data[pos:])
+		if !ok {
+			return nil, nil
+		}
+		records = append(records, r)
+		pos += size
+	}
+	return records, nil
+}
+
+// readIndex reads the index of a segment, it's rebuilt if it doesn't match header,
+// rebuilt is true if so
+func readIndex(l LogFormat, f File, header *FileHeader) (*walIndex, bool, error) {
+	records, err := readIndexFile(indexFileName(f.Path()))
+	if err != nil {
+		return nil, false, err
+	}
+	if records != nil {
+		if x := matchIndex(l, f, header, records); x != nil {
+			return x, false, nil
+		}
+	}
+	x, err := buildIndex(l, f, header)
+	if err != nil {
+		return nil, false, err
+	}
+	return x, true, nil
+}
+
+// loadIndex loads the index of a segment, it's rebuilt if it doesn't match header,
+// and written back if persist
+func loadIndex(l LogFormat, f File, header *FileHeader, persist bool) (*walIndex, error) {
+	x, rebuilt, err := readIndex(l, f, header)
+	if err != nil {
+		return nil, err
+	}
+	if rebuilt && persist {
+		filename := indexFileName(f.Path())
+		err = WriteFileAtomic(filename, encodeIndexRecords(x.encoded()))
+		if err != nil {
+			logger.Warn("write index of wal[%v] failed[%v]", f.Path(), err)
+		}
+	}
+	return x, nil
+}
+
+// openIndexFile opens index of the last segment for appending, it's rebuilt first
+// if it doesn't match header
+func openIndexFile(l LogFormat, f File, header *FileHeader) (*walIndex, *os.File, error) {
+	x, rebuilt, err := readIndex(l, f, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	filename := indexFileName(f.Path())
+	if rebuilt {
+		err = WriteFileAtomic(filename, encodeIndexRecords(x.encoded()))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	indexFile, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = indexFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		indexFile.Close()
+		return nil, nil, err
+	}
+	return x, indexFile, nil
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// appends n entries of 2 operations, the wal is split into segments of 10 operations
+func prepareIndexedWal(t assert.TestingT, n int) {
+	wal := Wal{}
+	err := wal.Init(getWalFile(), &BinLog{}, false)
+	assert.Nil(t, err)
+	defer wal.Close()
+	wal.SetSegmentLimit(0, 10)
+	for i := 0; i < n; i++ {
+		_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(2 * i)},
+			&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(2*i + 1)})
+		assert.Nil(t, err)
+	}
+}
+
+func TestWalIteratorAtNum(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 20)
+	segments, err := listSegments(walFileName)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(segments))
+	for _, segment := range segments {
+		assert.True(t, IsFile(indexFileName(segmentFileName(walFileName, segment))))
+	}
+
+	for _, readonly := range []bool{false, true} {
+		wal := Wal{}
+		err = wal.Init(walFileName, nil, readonly)
+		assert.Nil(t, err)
+		for _, num := range []int64{1, 2, 10, 11, 25, 40} {
+			i, err := wal.IteratorAtNum(num)
+			assert.Nil(t, err)
+			assert.True(t, i.Next())
+			assert.Equal(t, num, i.LogOp().Num)
+			assert.Equal(t, fmt.Sprint(num-1), i.LogOp().Value)
+		}
+		_, err = wal.IteratorAtNum(41)
+		assert.NotNil(t, err)
+		_, err = wal.IteratorAtNum(0)
+		assert.NotNil(t, err)
+		wal.Close()
+	}
+}
+
+func TestWalIndexFrom(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 20)
+
+	wal := Wal{}
+	err := wal.Init(walFileName, nil, true)
+	assert.Nil(t, err)
+	defer wal.Close()
+	i, err := wal.IteratorAtNum(24)
+	assert.Nil(t, err)
+	assert.True(t, i.Next())
+	gid := i.LogOp().Gid
+
+	i, err = wal.IteratorFrom(gid, true)
+	assert.Nil(t, err)
+	assert.True(t, i.Next())
+	assert.Equal(t, int64(24), i.LogOp().Num)
+	i, err = wal.IteratorFrom(gid, false)
+	assert.Nil(t, err)
+	assert.True(t, i.Next())
+	assert.Equal(t, int64(25), i.LogOp().Num)
+	_, err = wal.IteratorFrom("no such gid", false)
+	assert.NotNil(t, err)
+}
+
+func TestWalIndexRebuild(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 4)
+	indexFile := indexFileName(segmentFileName(walFileName, 0))
+
+	// readonly wal builds the index in memory
+	err := os.Remove(indexFile)
+	assert.Nil(t, err)
+	wal := Wal{}
+	err = wal.Init(walFileName, nil, true)
+	assert.Nil(t, err)
+	i, err := wal.IteratorAtNum(5)
+	assert.Nil(t, err)
+	assert.True(t, i.Next())
+	assert.Equal(t, int64(5), i.LogOp().Num)
+	wal.Close()
+	assert.False(t, IsFile(indexFile))
+
+	// stale index is rebuilt when opened for writing
+	err = os.WriteFile(indexFile, make([]byte, indexRecordHeaderSize), 0666)
+	assert.Nil(t, err)
+	wal = Wal{}
+	err = wal.Init(walFileName, nil, false)
+	assert.Nil(t, err)
+	// fewer entries than a record covers
+	stat, err := os.Stat(indexFile)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "8"})
+	assert.Nil(t, err)
+	i, err = wal.IteratorAtNum(9)
+	assert.Nil(t, err)
+	assert.True(t, i.Next())
+	assert.Equal(t, "8", i.LogOp().Value)
+	wal.Close()
+
+	stat, err = os.Stat(indexFile)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size())
+}
+
+func TestWalIndexSparse(t *testing.T) {
+	t.Cleanup(delWalFile)
+	delWalFile()
+	entries := 3*indexInterval + indexInterval/2
+	wal := Wal{}
+	err := wal.Init(getWalFile(), &BinLog{}, false)
+	assert.Nil(t, err)
+	gids := []string{}
+	for i := 0; i < entries; i++ {
+		ops := []*LogOperation{{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(2 * i)},
+			{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(2*i + 1)}}
+		_, _, err = wal.Append(ops...)
+		assert.Nil(t, err)
+		gids = append(gids, ops[0].Gid, ops[1].Gid)
+	}
+	wal.Close()
+
+	// a record every indexInterval entries
+	indexFile := indexFileName(segmentFileName(walFileName, 0))
+	records, err := readIndexFile(indexFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(records))
+	recordSize := indexRecordHeaderSize + 8*2*indexInterval
+	data, err := os.ReadFile(indexFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 3*recordSize, len(data))
+
+	check := func(readonly bool) {
+		wal := Wal{}
+		err := wal.Init(walFileName, nil, readonly)
+		assert.Nil(t, err)
+		defer wal.Close()
+		for num := int64(1); num <= int64(len(gids)); num++ {
+			i, err := wal.IteratorAtNum(num)
+			assert.Nil(t, err)
+			assert.True(t, i.Next())
+			assert.Equal(t, gids[num-1], i.LogOp().Gid)
+
+			i, err = wal.IteratorFrom(gids[num-1], false)
+			assert.Nil(t, err)
+			if num < int64(len(gids)) {
+				assert.True(t, i.Next())
+				assert.Equal(t, num+1, i.LogOp().Num)
+			} else {
+				assert.False(t, i.Next())
+			}
+		}
+	}
+	check(true)
+
+	// a record missing is rebuilt
+	err = os.Truncate(indexFile, int64(recordSize))
+	assert.Nil(t, err)
+	check(false)
+	rebuilt, err := os.ReadFile(indexFile)
+	assert.Nil(t, err)
+	assert.Equal(t, data, rebuilt)
+
+	// so is a damaged record
+	data[recordSize+8]++
+	err = os.WriteFile(indexFile, data, 0666)
+	assert.Nil(t, err)
+	check(false)
+	rebuilt, err = os.ReadFile(indexFile)
+	assert.Nil(t, err)
+	data[recordSize+8]--
+	assert.Equal(t, data, rebuilt)
+
+	// and a record intact but mismatching the log
+	records[1].num++
+	err = os.WriteFile(indexFile, encodeIndexRecords(records), 0666)
+	assert.Nil(t, err)
+	check(false)
+	rebuilt, err = os.ReadFile(indexFile)
+	assert.Nil(t, err)
+	assert.Equal(t, data, rebuilt)
+}
+
+func TestWalIndexFindGid(t *testing.T) {
+	x := walIndex{}
+	gids := []string{}
+	for i := 0; i < 3*indexInterval+1; i++ {
+		op := &LogOperation{Gid: fmt.Sprint("gid", i), Num: int64(i + 1)}
+		x.add(int64(HeaderSize+i*10), []*LogOperation{op})
+		gids = append(gids, op.Gid)
+	}
+	for i, gid := range gids {
+		ranges := x.findGid(gid)
+		assert.Equal(t, 1, len(ranges))
+		block := i / indexInterval
+		assert.Equal(t, int64(HeaderSize+block*indexInterval*10), ranges[0].offset)
+		if block < 3 {
+			assert.Equal(t, int64((block+1)*indexInterval+1), ranges[0].end)
+		} else {
+			assert.Equal(t, int64(math.MaxInt64), ranges[0].end)
+		}
+	}
+	assert.Equal(t, 0, len(x.findGid("no such gid")))
+}
+
+func TestWalIndexTrim(t *testing.T) {
+	t.Cleanup(delWalFile)
+	prepareIndexedWal(t, 20)
+
+	wal := Wal{}
+	err := wal.Init(walFileName, nil, false)
+	assert.Nil(t, err)
+	defer wal.Close()
+	removed, err := wal.Trim(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	assert.False(t, IsFile(indexFileName(segmentFileName(walFileName, 0))))
+	assert.False(t, IsFile(indexFileName(segmentFileName(walFileName, 1))))
+	assert.True(t, IsFile(indexFileName(segmentFileName(walFileName, 2))))
+
+	_, err = wal.IteratorAtNum(20)
+	assert.NotNil(t, err)
+	i, err := wal.IteratorAtNum(21)
+	assert.Nil(t, err)
+	assert.True(t, i.Next())
+	assert.Equal(t, int64(21), i.LogOp().Num)
+}
@@ -18,13 +18,16 @@ func getWalFile() string {
 func delWalFile() {
 	os.RemoveAll("./data")
 
-	_, err := os.Stat(walFileName)
+	segments, err := listSegments(walFileName)
 	if err != nil {
 		return
 	}
-	err = os.Remove(walFileName)
-	if err != nil {
-		fmt.Println(err)
+	for _, segment := range segments {
+		err = os.Remove(segmentFileName(walFileName, segment))
+		if err != nil {
+			fmt.Println(err)
+		}
+		os.Remove(indexFileName(segmentFileName(walFileName, segment)))
 	}
 }
 
@@ -198,3 +201,259 @@ func TestWalRangeIterator(t *testing.T) {
 	}
 	assert.Equal(t, 3, num)
 }
+
+func TestWalSegments(t *testing.T) {
+	delWalFile()
+	t.Cleanup(delWalFile)
+
+	wal := Wal{}
+	err := wal.Init(walFileName, &BinLog{}, false)
+	assert.Nil(t, err)
+	wal.SetSegmentLimit(0, 2)
+
+	const testKey = "testKey"
+	const testValue = "testValue"
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: testKey + "1", Value: testValue + "1"})
+	assert.Nil(t, err)
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: testKey + "2", Value: testValue + "2"})
+	assert.Nil(t, err)
+	gid, _, err := wal.Append(&LogOperation{Op: int32(Op_Modify), Key: testKey + "3", Value: testValue + "3"})
+	assert.Nil(t, err)
+	_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: testKey + "3", Value: testValue + "4"})
+	assert.Nil(t, err)
+	_, num, err := wal.Append(&LogOperation{Op: int32(Op_Del), Key: testKey + "1", Value: ""})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), num)
+	assert.Equal(t, int64(2), wal.Segment())
+	assert.Nil(t, wal.Close())
+
+	segments, err := listSegments(walFileName)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0, 1, 2}, segments)
+	assert.True(t, IsFile("test.1.wal"))
+	assert.True(t, IsFile("test.2.wal"))
+
+	wal = Wal{}
+	err = wal.Init(walFileName, &BinLog{}, true)
+	assert.Nil(t, err)
+	defer wal.Close()
+	assert.Equal(t, int64(5), wal.EntryNum())
+
+	nums := []int64{}
+	i := wal.Iterator()
+	for i.Next() {
+		nums = append(nums, i.LogOp().Num)
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, nums)
+
+	num = 0
+	i, err = wal.IteratorFrom(gid, false)
+	assert.Nil(t, err)
+	for i.Next() {
+		num++
+	}
+	assert.Equal(t, int64(2), num)
+
+	nums = []int64{}
+	i, err = wal.IteratorAt(1, HeaderSize)
+	assert.Nil(t, err)
+	for i.Next() {
+		nums = append(nums, i.LogOp().Num)
+		assert.GreaterOrEqual(t, i.Segment(), int64(1))
+	}
+	assert.Equal(t, []int64{3, 4, 5}, nums)
+}
+
+func TestWalTrim(t *testing.T) {
+	delWalFile()
+	t.Cleanup(delWalFile)
+
+	wal := Wal{}
+	err := wal.Init(walFileName, &BinLog{}, false)
+	assert.Nil(t, err)
+	defer wal.Close()
+	wal.SetSegmentLimit(0, 1)
+
+	const testKey = "testKey"
+	const testValue = "testValue"
+	for i := 0; i < 4; i++ {
+		_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: testKey, Value: testValue + fmt.Sprint(i)})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int64(3), wal.Segment())
+
+	removed, err := wal.Trim(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	assert.False(t, IsFile(walFileName))
+	assert.True(t, walExists(walFileName))
+
+	_, err = wal.IteratorAt(1, HeaderSize)
+	assert.NotNil(t, err)
+
+	nums := []int64{}
+	i := wal.Iterator()
+	for i.Next() {
+		nums = append(nums, i.LogOp().Num)
+	}
+	assert.Equal(t, []int64{3, 4}, nums)
+
+	// the last segment is never removed
+	removed, err = wal.Trim(100)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+	_, num, err := wal.Append(&LogOperation{Op: int32(Op_Del), Key: testKey})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), num)
+}
+
+func appendTestEntries(t assert.TestingT, l LogFormat, n int) {
+	wal := Wal{}
+	err := wal.Init(walFileName, l, false)
+	assert.Nil(t, err)
+	defer wal.Close()
+	for i := 0; i < n; i++ {
+		_, _, err = wal.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(i)})
+		assert.Nil(t, err)
+	}
+}
+
+func countWalEntries(t assert.TestingT, l LogFormat) int {
+	wal := Wal{}
+	err := wal.Init(walFileName, l, true)
+	assert.Nil(t, err)
+	defer wal.Close()
+	num := 0
+	i := wal.Iterator()
+	for i.Next() {
+		num++
+	}
+	return num
+}
+
+func TestWalRecoverTornTail(t *testing.T) {
+	delWalFile()
+	t.Cleanup(delWalFile)
+
+	appendTestEntries(t, &BinLog{}, 2)
+	stat, err := os.Stat(walFileName)
+	assert.Nil(t, err)
+	f, err := os.OpenFile(walFileName, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Nil(t, err)
+	// a torn entry, claiming to be much larger than it is
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0x7f, 1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	wal := Wal{}
+	err = wal.Init(walFileName, &BinLog{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), wal.EntryNum())
+	assert.Equal(t, stat.Size(), wal.Offset())
+	_, num, err := wal.Append(&LogOperation{Op: int32(Op_Del), Key: "testKey"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), num)
+	assert.Nil(t, wal.Close())
+
+	assert.Equal(t, 3, countWalEntries(t, &BinLog{}))
+}
+
+func TestWalRecoverUnrecordedEntries(t *testing.T) {
+	delWalFile()
+	t.Cleanup(delWalFile)
+
+	appendTestEntries(t, &BinLog{}, 2)
+	// crash between writing the entry and the header
+	f, err := OpenFile(walFileName, false)
+	assert.Nil(t, err)
+	l := BinLog{}
+	header, err := l.ReadHeader(f)
+	assert.Nil(t, err)
+	_, err = l.AppendEntry(f, header.FileEnd, &LogEntry{Ops: []*LogOperation{
+		{Op: int32(Op_Del), Key: "testKey", Gid: "gid3", Num: 3}}})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, 2, countWalEntries(t, &BinLog{}))
+
+	wal := Wal{}
+	err = wal.Init(walFileName, &BinLog{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), wal.EntryNum())
+	assert.Nil(t, wal.Close())
+	assert.Equal(t, 3, countWalEntries(t, &BinLog{}))
+}
+
+func TestWalRecoverDamagedHeader(t *testing.T) {
+	delWalFile()
+	t.Cleanup(delWalFile)
+
+	appendTestEntries(t, &BinLog{}, 2)
+	f, err := os.OpenFile(walFileName, os.O_WRONLY, 0666)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	wal := Wal{}
+	err = wal.Init(walFileName, &BinLog{}, true)
+	assert.NotNil(t, err)
+
+	wal = Wal{}
+	err = wal.Init(walFileName, &BinLog{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), wal.EntryNum())
+	_, num, err := wal.Append(&LogOperation{Op: int32(Op_Del), Key: "testKey"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), num)
+	assert.Nil(t, wal.Close())
+	assert.Equal(t, 3, countWalEntries(t, &BinLog{}))
+}
+
+func TestWalRefresh(t *testing.T) {
+	t.Cleanup(delWalFile)
+
+	w := Wal{}
+	err := w.Init(getWalFile(), &BinLog{}, false)
+	assert.Nil(t, err)
+	defer w.Close()
+	w.SetSegmentLimit(0, 4)
+	_, _, err = w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "0"})
+	assert.Nil(t, err)
+
+	r := Wal{}
+	err = r.InitMmap(walFileName, nil)
+	assert.Nil(t, err)
+	defer r.Close()
+
+	changed, err := r.Refresh()
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	_, _, err = w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: "1"})
+	assert.Nil(t, err)
+	changed, err = r.Refresh()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, int64(2), r.EntryNum())
+	_, err = w.Refresh()
+	assert.NotNil(t, err)
+
+	// a new segment is created
+	for i := 2; i < 6; i++ {
+		_, _, err = w.Append(&LogOperation{Op: int32(Op_Modify), Key: "testKey", Value: fmt.Sprint(i)})
+		assert.Nil(t, err)
+	}
+	changed, err = r.Refresh()
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, int64(6), r.EntryNum())
+	assert.Equal(t, int64(1), r.Segment())
+
+	n := 0
+	it := r.Iterator()
+	for it.Next() {
+		assert.Equal(t, fmt.Sprint(n), it.LogOp().Value)
+		n++
+	}
+	assert.Equal(t, 6, n)
+}
@@ -0,0 +1,169 @@
+//go:build linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	watchWdMask       = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+	watchPersonalMask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+		syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_ONLYDIR
+)
+
+// Watcher notices new participant directories and changed wal files in the
+// working directory by inotify, changes are coalesced into Changes
+type Watcher struct {
+	wd      string
+	fd      int // don't call f.Fd(), which makes f blocking
+	f       *os.File
+	mu      sync.Mutex
+	watches map[int32]string // watch descriptor -> watched directory
+	changes chan struct{}
+	done    chan struct{}
+}
+
+// Init starts watching wd and all participant directories in it
+func (w *Watcher) Init(wd string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	w.wd = wd
+	w.fd = fd
+	// nonblocking fd is served by the runtime poller, so Close interrupts Read
+	w.f = os.NewFile(uintptr(fd), "inotify")
+	w.watches = make(map[int32]string)
+	w.changes = make(chan struct{}, 1)
+	w.done = make(chan struct{})
+
+	if err := w.addWatch(wd, watchWdMask); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.watchParticipants(); err != nil {
+		w.f.Close()
+		return err
+	}
+	go w.loop()
+	return nil
+}
+
+// Changes is signaled when something may have changed
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops watching, Changes is closed when it returns
+func (w *Watcher) Close() error {
+	err := w.f.Close()
+	<-w.done
+	return err
+}
+
+func (w *Watcher) addWatch(dir string, mask uint32) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, mask)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.watches[int32(wd)] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+// watchParticipants watches directories of participants, watching one twice is harmless
+func (w *Watcher) watchParticipants() error {
+	entries, err := os.ReadDir(w.wd)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		err := w.addWatch(path.Join(w.wd, entry.Name()), watchPersonalMask)
+		if err != nil {
+			logger.Warn("watch directory[%v] failed[%v]", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.changes <- struct{}{}:
+	default:
+	}
+}
+
+func isWalFileName(name string) bool {
+	return strings.HasSuffix(name, path.Ext(WalFileName))
+}
+
+func (w *Watcher) loop() {
+	defer close(w.done)
+	defer close(w.changes)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				logger.Error("read inotify events failed[%v]", err)
+			}
+			return
+		}
+
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := strings.TrimRight(string(nameBytes), "\x00")
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// events lost, anything may have changed
+				if err := w.watchParticipants(); err != nil {
+					logger.Warn("watch participants failed[%v]", err)
+				}
+				changed = true
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.watches[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.watches, event.Wd)
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			if dir == w.wd {
+				if event.Mask&syscall.IN_ISDIR != 0 {
+					// a new participant
+					if err := w.addWatch(path.Join(w.wd, name), watchPersonalMask); err != nil {
+						logger.Warn("watch directory[%v] failed[%v]", name, err)
+					}
+					changed = true
+				}
+				continue
+			}
+			if isWalFileName(name) {
+				changed = true
+			}
+		}
+		if changed {
+			w.notify()
+		}
+	}
+}
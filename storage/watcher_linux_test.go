@@ -0,0 +1,90 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitChange(w *Watcher) bool {
+	select {
+	case <-w.Changes():
+		return true
+	case <-time.After(200 * time.Millisecond):
+		return false
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	wd := t.TempDir()
+	w := Watcher{}
+	err := w.Init(wd)
+	assert.Nil(t, err)
+
+	personal := path.Join(wd, "machine0")
+	err = os.Mkdir(personal, 0777)
+	assert.Nil(t, err)
+	assert.True(t, waitChange(&w))
+
+	// only wal files matter
+	err = os.WriteFile(path.Join(personal, ProgressFileName), []byte("{}"), 0666)
+	assert.Nil(t, err)
+	assert.False(t, waitChange(&w))
+
+	err = os.WriteFile(path.Join(personal, WalFileName), []byte("wal"), 0666)
+	assert.Nil(t, err)
+	assert.True(t, waitChange(&w))
+
+	err = w.Close()
+	assert.Nil(t, err)
+	_, ok := <-w.Changes()
+	assert.False(t, ok)
+}
+
+func TestParticipantWatch(t *testing.T) {
+	wd := t.TempDir()
+	s0 := Participant{}
+	err := s0.InitWithOptions(wd, "machine0", &ParticipantOptions{Watch: true})
+	assert.Nil(t, err)
+	defer s0.Close()
+	assert.NotNil(t, s0.watcher)
+
+	_, err = s0.All()
+	assert.Nil(t, err)
+	s0.mu.Lock()
+	lastReplay := s0.lastReplay
+	s0.mu.Unlock()
+	// nothing changed
+	_, err = s0.All()
+	assert.Nil(t, err)
+	assert.Equal(t, lastReplay, s0.lastReplay)
+
+	// our own writes are replayed at once
+	err = s0.Save("key0", "value0")
+	assert.Nil(t, err)
+	v, err := s0.Load("key0")
+	assert.Nil(t, err)
+	assert.Equal(t, "value0", v.Main().value)
+
+	// a participant joins later
+	s1 := Participant{}
+	err = s1.Init(wd, "machine1")
+	assert.Nil(t, err)
+	err = s1.Save("key1", "value1")
+	assert.Nil(t, err)
+	s1.Close()
+
+	assert.Eventually(t, func() bool {
+		s0.mu.Lock()
+		defer s0.mu.Unlock()
+		return s0.m.Get("machine1").Num == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	v, err = s0.Load("key1")
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v.Main().value)
+}
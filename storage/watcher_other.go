@@ -0,0 +1,21 @@
+//go:build !linux
+
+package storage
+
+import "fmt"
+
+// Watcher is only supported on linux
+type Watcher struct {
+}
+
+func (w *Watcher) Init(wd string) error {
+	return fmt.Errorf("watcher is not supported on this platform")
+}
+
+func (w *Watcher) Changes() <-chan struct{} {
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}
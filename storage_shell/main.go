@@ -1,10 +1,12 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/CQUST-Runner/datacross/storage"
 
@@ -14,6 +16,24 @@ import (
 type Config struct {
 	WorkingDirectory string `yaml:"wd" default:"./data"`
 	MachineName      string `yaml:"machine_name" default:"machine0"`
+	// format of the wal when it's created: bin, json, flate or aes
+	LogFormat string `yaml:"log_format"`
+	// secret of aes format, shared by all participants
+	Passphrase string `yaml:"passphrase"`
+	KeyFile    string `yaml:"key_file"`
+	// created if not exist, keep it out of wd
+	SigningKeyFile string `yaml:"signing_key_file"`
+	// public keys of participants by name, see `keys` command
+	TrustedKeys map[string]string `yaml:"trusted_keys"`
+	// when writes are fsynced: always, every_n, periodic or never
+	SyncMode       string `yaml:"sync_mode"`
+	SyncCount      int    `yaml:"sync_count"`
+	SyncIntervalMs int    `yaml:"sync_interval_ms"`
+	// replay in background on changes, linux only
+	Watch bool `yaml:"watch"`
+	// write what's replayed to db periodically or after so many operations, 0 disables
+	CheckpointIntervalMs int   `yaml:"checkpoint_interval_ms"`
+	CheckpointEntries    int64 `yaml:"checkpoint_entries"`
 }
 
 func loadConfig(filename string, c *Config) error {
@@ -26,9 +46,32 @@ func loadConfig(filename string, c *Config) error {
 
 var c *Config
 
+// makeLogFormat returns nil if no format is configured
+func makeLogFormat(c *Config) (storage.LogFormat, error) {
+	if c.Passphrase != "" || c.KeyFile != "" {
+		if c.LogFormat != "" && c.LogFormat != "aes" {
+			return nil, fmt.Errorf("secret is only used by aes format")
+		}
+		l := storage.CryptLog{}
+		if c.KeyFile != "" {
+			return &l, l.InitWithKeyFile(c.KeyFile)
+		}
+		return &l, l.InitWithPassphrase(c.Passphrase)
+	}
+	if c.LogFormat == "" {
+		return nil, nil
+	}
+	if c.LogFormat == "aes" {
+		return nil, fmt.Errorf("passphrase or key_file is required by aes format")
+	}
+	return storage.LogFormatByName(c.LogFormat)
+}
+
 func main() {
 	confFile := ""
+	takeover := false
 	flag.StringVar(&confFile, "conf", "conf.yaml", "config file path")
+	flag.BoolVar(&takeover, "takeover", false, "take over the directory of machine_name owned by another host, which must not be running")
 	flag.Parse()
 
 	conf := Config{}
@@ -40,13 +83,53 @@ func main() {
 	fmt.Println("conf: ", conf)
 	c = &conf
 
+	opts := storage.ParticipantOptions{Watch: c.Watch, Takeover: takeover,
+		CheckpointInterval: time.Duration(c.CheckpointIntervalMs) * time.Millisecond,
+		CheckpointEntries:  c.CheckpointEntries}
+	opts.LogFormat, err = makeLogFormat(c)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if c.SigningKeyFile != "" {
+		opts.SigningKey, err = storage.LoadOrCreateSigningKey(c.SigningKeyFile)
+		if err != nil {
+			fmt.Println("load signing key failed", err)
+			return
+		}
+	}
+	if c.SyncMode != "" {
+		opts.Durability.Mode, err = storage.ParseSyncMode(c.SyncMode)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts.Durability.Count = c.SyncCount
+		opts.Durability.Interval = time.Duration(c.SyncIntervalMs) * time.Millisecond
+	}
+	if c.TrustedKeys != nil {
+		opts.TrustedKeys = make(map[string]ed25519.PublicKey)
+		for name, s := range c.TrustedKeys {
+			key, err := storage.ParsePublicKey(s)
+			if err != nil {
+				fmt.Printf("invalid trusted key of [%v] %v\n", name, err)
+				return
+			}
+			opts.TrustedKeys[name] = key
+		}
+	}
+
 	participant := storage.Participant{}
-	err = participant.Init(c.WorkingDirectory, c.MachineName)
+	err = participant.InitWithOptions(c.WorkingDirectory, c.MachineName, &opts)
 	if err != nil {
 		fmt.Println("init participant failed", err)
 		return
 	}
-	defer participant.Close()
+	defer func() {
+		if err := participant.Close(); err != nil {
+			fmt.Println("close participant failed", err)
+		}
+	}()
 
 	s := Shell{}
 	s.Init(os.Stdin, os.Stdout, &participant)
@@ -4,15 +4,19 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/CQUST-Runner/datacross/storage"
 )
 
 type Shell struct {
-	r io.Reader
-	w io.Writer
-	p *storage.Participant
+	r   io.Reader
+	w   io.Writer
+	p   *storage.Participant
+	sub *storage.Subscription // printing changes if not nil
 }
 
 func (s *Shell) Init(input io.Reader, output io.Writer, p *storage.Participant) {
@@ -145,6 +149,274 @@ func (s *Shell) resolve(w io.Writer, args ...string) {
 	fmt.Fprintln(w, "ok")
 }
 
+func (s *Shell) trim(w io.Writer, args ...string) {
+	removed, err := s.p.Trim()
+	if err != nil {
+		fmt.Fprintln(w, "trim failed", err)
+		return
+	}
+	fmt.Fprintf(w, "%v segments removed\n", removed)
+}
+
+func (s *Shell) checkpoint(w io.Writer, args ...string) {
+	err := s.p.Checkpoint()
+	if err != nil {
+		fmt.Fprintln(w, "checkpoint failed", err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Shell) progress(w io.Writer, args ...string) {
+	err := s.p.PublishProgress()
+	if err != nil {
+		fmt.Fprintln(w, "publish progress failed", err)
+	}
+
+	all := s.p.PeerProgress()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		published := all[name]
+		fmt.Fprintf(w, "%v (published at %v)\n", name, published.PublishedAt.Format(time.RFC3339))
+		machines := make([]string, 0, len(published.Replayed))
+		for machine := range published.Replayed {
+			machines = append(machines, machine)
+		}
+		sort.Strings(machines)
+		for _, machine := range machines {
+			progress := published.Replayed[machine]
+			// how many operations behind the log owner itself
+			lag := int64(0)
+			if owner, ok := all[machine]; ok {
+				if self, ok := owner.Replayed[machine]; ok {
+					lag = self.Num - progress.Num
+				}
+			}
+			fmt.Fprintf(w, "\t%v\tnum %v\tlag %v\tlast applied %v\n", machine, progress.Num, lag,
+				progress.AppliedAt.Format(time.RFC3339))
+		}
+	}
+}
+
+func (s *Shell) status(w io.Writer, args ...string) {
+	all, err := s.p.Status()
+	if err != nil {
+		fmt.Fprintln(w, "get status failed", err)
+		return
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		status := all[name]
+		state := "ok"
+		switch {
+		case !status.Trusted:
+			state = "untrusted"
+		case status.Quarantine != nil:
+			state = "quarantined"
+		case status.Err != nil:
+			state = "stopped"
+		case status.Blocked != nil:
+			state = "blocked"
+		}
+		fmt.Fprintf(w, "%v\t%v\tnum %v/%v\tbehind %v\n", name, state, status.Num, status.EntryNum, status.Behind())
+		if status.Err != nil {
+			fmt.Fprintf(w, "\t%v\n", status.Err)
+		}
+		if status.Blocked != nil {
+			fmt.Fprintf(w, "\t%v\n", status.Blocked)
+		}
+		if status.Quarantine != nil {
+			fmt.Fprintf(w, "\t%v\n", status.Quarantine)
+		}
+	}
+}
+
+func (s *Shell) readmit(w io.Writer, args ...string) {
+	if len(args) < 1 {
+		fmt.Fprintln(w, "too few args, usage: readmit <machine>")
+		return
+	}
+	err := s.p.Readmit(args[0])
+	if err != nil {
+		fmt.Fprintln(w, "readmit failed", err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// dryrun tells what replaying pending operations would do
+func (s *Shell) dryrun(w io.Writer, args ...string) {
+	report, err := s.p.DryRun()
+	if err != nil {
+		fmt.Fprintln(w, "dry run failed", err)
+		return
+	}
+
+	names := make([]string, 0, len(report.Applied))
+	for name := range report.Applied {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "apply %v operations of %v\n", report.Applied[name], name)
+	}
+	for _, c := range report.Changed {
+		before := "(none)"
+		if c.Before != nil {
+			before = c.Before.Main().Value()
+		}
+		fmt.Fprintf(w, "change %v: %v -> %v\n", c.Key, before, c.After.Main().Value())
+	}
+	for _, key := range report.Deleted {
+		fmt.Fprintf(w, "delete %v\n", key)
+	}
+	for _, key := range report.Conflicts {
+		fmt.Fprintf(w, "conflict %v\n", key)
+	}
+	for name, b := range report.Blocked {
+		fmt.Fprintf(w, "blocked %v: %v\n", name, b)
+	}
+	for name, err := range report.Failed {
+		fmt.Fprintf(w, "failed %v: %v\n", name, err)
+	}
+}
+
+func (s *Shell) sync(w io.Writer, args ...string) {
+	err := s.p.Sync()
+	if err != nil {
+		fmt.Fprintln(w, "sync failed", err)
+	}
+}
+
+func (s *Shell) keys(w io.Writer, args ...string) {
+	all := s.p.PublicKeys()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		trusted := "untrusted"
+		if s.p.Trusted(name) {
+			trusted = "trusted"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", name, storage.EncodePublicKey(all[name]), trusted)
+	}
+}
+
+// at lists values when logs were replayed to the points given,
+// or to where a participant has replayed them
+func (s *Shell) at(w io.Writer, args ...string) {
+	if len(args) < 1 {
+		fmt.Fprintln(w, "too few args, usage: at <machine>=<num|gid>... or at <machine>")
+		return
+	}
+
+	points := make(map[string]storage.StopPoint)
+	if len(args) == 1 && !strings.Contains(args[0], "=") {
+		published, ok := s.p.PeerProgress()[args[0]]
+		if !ok {
+			fmt.Fprintf(w, "[%v] has not published its progress\n", args[0])
+			return
+		}
+		points = published.StopPoints()
+	} else {
+		for _, arg := range args {
+			name, point, ok := strings.Cut(arg, "=")
+			if !ok || name == "" || point == "" {
+				fmt.Fprintln(w, "invalid stop point", arg)
+				return
+			}
+			if num, err := strconv.ParseInt(point, 10, 64); err == nil {
+				points[name] = storage.StopPoint{Num: num}
+			} else {
+				points[name] = storage.StopPoint{Gid: point}
+			}
+		}
+	}
+
+	snapshot, err := s.p.SnapshotAt(points)
+	if err != nil {
+		fmt.Fprintln(w, "replay failed", err)
+		return
+	}
+	records, err := snapshot.All()
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, records)
+}
+
+func (s *Shell) changes(w io.Writer, args ...string) {
+	if len(args) < 1 {
+		fmt.Fprintln(w, "too few args, usage: changes <duration>, e.g. changes 1h")
+		return
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		fmt.Fprintln(w, "invalid duration", err)
+		return
+	}
+
+	changes, err := s.p.ChangedSince(storage.NewHLCTimestamp(time.Now().Add(-d), 0))
+	if err != nil {
+		fmt.Fprintln(w, "get changes failed", err)
+		return
+	}
+	for _, c := range changes {
+		if c.Value == nil {
+			fmt.Fprintf(w, "%v\t%v\t(deleted)\n", c.Timestamp, c.Key)
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\n", c.Timestamp, c.Value)
+	}
+}
+
+// watch prints changes of keys with prefix in background until unwatch
+func (s *Shell) watch(w io.Writer, args ...string) {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	s.unwatch(w)
+	s.sub = s.p.Watch(prefix)
+	go func(c <-chan *storage.ChangeEvent) {
+		for e := range c {
+			from := e.MachineID
+			if e.Local {
+				from = "local"
+			}
+			value := "(deleted)"
+			if e.Value != nil {
+				value = e.Value.String()
+			}
+			if e.NewBranch {
+				value += " (new branch)"
+			}
+			fmt.Fprintf(w, "[%v] %v %v by %v: %v\n", e.Timestamp, e.Op, e.Key, from, value)
+		}
+	}(s.sub.C())
+}
+
+func (s *Shell) unwatch(w io.Writer, args ...string) {
+	if s.sub != nil {
+		s.sub.Close()
+		s.sub = nil
+	}
+}
+
 func (s *Shell) help(w io.Writer, args ...string) {
 	fmt.Fprintln(w, `
 list
@@ -154,6 +426,18 @@ has <key>
 set <key> <value>
 resolve <key>
 conflicts
+changes <duration>
+at <machine>=<num|gid>... | at <machine>
+progress
+checkpoint
+status
+dryrun
+watch [prefix]
+unwatch
+readmit <machine>
+trim
+sync
+keys
 help
 exit
 	`)
@@ -187,6 +471,30 @@ func (s *Shell) exec(w io.Writer, cmd string) {
 		s.resolve(w, tokens[1:]...)
 	case "conflicts":
 		s.conflicts(w, tokens[1:]...)
+	case "at":
+		s.at(w, tokens[1:]...)
+	case "changes":
+		s.changes(w, tokens[1:]...)
+	case "progress":
+		s.progress(w, tokens[1:]...)
+	case "checkpoint":
+		s.checkpoint(w, tokens[1:]...)
+	case "status":
+		s.status(w, tokens[1:]...)
+	case "dryrun":
+		s.dryrun(w, tokens[1:]...)
+	case "watch":
+		s.watch(w, tokens[1:]...)
+	case "unwatch":
+		s.unwatch(w, tokens[1:]...)
+	case "readmit":
+		s.readmit(w, tokens[1:]...)
+	case "sync":
+		s.sync(w, tokens[1:]...)
+	case "keys":
+		s.keys(w, tokens[1:]...)
+	case "trim":
+		s.trim(w, tokens[1:]...)
 	case "help":
 		s.help(w, tokens[1:]...)
 	default:
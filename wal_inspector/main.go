@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/CQUST-Runner/datacross/storage"
+)
+
+type dumpedOp struct {
+	Num           int64            `json:"num"`
+	Gid           string           `json:"gid"`
+	Op            string           `json:"op"`
+	Key           string           `json:"key"`
+	Value         string           `json:"value"`
+	MachineID     string           `json:"machine_id"`
+	Seq           uint64           `json:"seq"`
+	Hlc           *dumpedHlc       `json:"hlc,omitempty"` // nil if logged before timestamps were introduced
+	PrevGid       string           `json:"prev_gid,omitempty"`
+	PrevNum       int64            `json:"prev_num,omitempty"`
+	PrevMachineID string           `json:"prev_machine_id,omitempty"`
+	PrevValue     string           `json:"prev_value,omitempty"`
+	Changes       map[string]int32 `json:"changes,omitempty"`
+}
+
+type dumpedHlc struct {
+	Time    string `json:"time"`
+	Logical uint16 `json:"logical"`
+}
+
+// one line per entry
+type dumpedEntry struct {
+	Segment int64       `json:"segment"`
+	Offset  int64       `json:"offset"`
+	Size    int64       `json:"size"`
+	Signed  bool        `json:"signed"`
+	Ops     []*dumpedOp `json:"ops"`
+}
+
+func dumpOp(op *storage.LogOperation) *dumpedOp {
+	d := &dumpedOp{
+		Num:           op.Num,
+		Gid:           op.Gid,
+		Op:            storage.Op(op.Op).String(),
+		Key:           op.Key,
+		Value:         op.Value,
+		MachineID:     op.MachineId,
+		Seq:           op.Seq,
+		PrevGid:       op.PrevGid,
+		PrevNum:       op.PrevNum,
+		PrevMachineID: op.PrevMachineId,
+		PrevValue:     op.PrevValue,
+		Changes:       op.Changes,
+	}
+	if hlc := storage.HLCTimestamp(op.Hlc); !hlc.IsZero() {
+		d.Hlc = &dumpedHlc{Time: hlc.Time().UTC().Format("2006-01-02T15:04:05.000Z07:00"), Logical: hlc.Logical()}
+	}
+	return d
+}
+
+// dump exits with 2 after the dump if some segment is damaged, the rest of it is skipped
+func dump(filename string, l storage.LogFormat, filter *storage.OpFilter) error {
+	encoder := json.NewEncoder(os.Stdout)
+	problems, err := storage.ScanWal(filename, l, func(e *storage.ScannedEntry) error {
+		ops := filter.Filter(e.Entry)
+		if len(ops) == 0 {
+			return nil
+		}
+		d := dumpedEntry{Segment: e.Segment, Offset: e.Offset, Size: e.Size, Signed: len(e.Entry.Signature) > 0}
+		for _, op := range ops {
+			d.Ops = append(d.Ops, dumpOp(op))
+		}
+		return encoder.Encode(&d)
+	})
+	if err != nil {
+		return err
+	}
+	skipped(problems)
+	return nil
+}
+
+// skipped reports problems of damaged segments, exits with 2 if there is any
+func skipped(problems []*storage.WalProblem) {
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, "bad", problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(2)
+	}
+}
+
+// stats exits with 2 as dump does
+func stats(filename string, l storage.LogFormat, filter *storage.OpFilter) error {
+	s, err := storage.CollectWalStats(filename, l, filter)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("segments:    %v\n", s.Segments)
+	fmt.Printf("file bytes:  %v\n", s.FileBytes)
+	fmt.Printf("entries:     %v\n", s.Entries)
+	fmt.Printf("entry bytes: %v\n", s.EntryBytes)
+	fmt.Printf("ops:         %v\n", s.Ops)
+	types := []string{}
+	for name := range s.OpsByType {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for _, name := range types {
+		fmt.Printf("  %-10v %v\n", name, s.OpsByType[name])
+	}
+	fmt.Printf("keys:        %v\n", s.Keys)
+	skipped(s.Problems)
+	return nil
+}
+
+// verify exits with 2 if a problem is found
+func verify(filename string, l storage.LogFormat) error {
+	problem, err := storage.CheckWal(filename, l)
+	if err != nil {
+		return err
+	}
+	if problem != nil {
+		fmt.Println("bad", problem)
+		os.Exit(2)
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [flags] dump|stats|verify <wal>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	key := flag.String("key", "", "only operations on key")
+	gid := flag.String("gid", "", "only the operation of gid")
+	machine := flag.String("machine", "", "only operations made by machine")
+	op := flag.String("op", "", "only operations of type: modify, del or discard")
+	passphrase := flag.String("passphrase", "", "secret of aes format")
+	keyFile := flag.String("keyfile", "", "file containing secret of aes format")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(1)
+	}
+	cmd := flag.Arg(0)
+	filename := flag.Arg(1)
+
+	var l storage.LogFormat
+	if *passphrase != "" || *keyFile != "" {
+		crypt := storage.CryptLog{}
+		var err error
+		if *keyFile != "" {
+			err = crypt.InitWithKeyFile(*keyFile)
+		} else {
+			err = crypt.InitWithPassphrase(*passphrase)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		l = &crypt
+	}
+
+	filter := storage.OpFilter{Key: *key, Gid: *gid, MachineID: *machine}
+	if *op != "" {
+		o, err := storage.ParseOp(*op)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		filter.Op = &o
+	}
+
+	var err error
+	switch cmd {
+	case "dump":
+		err = dump(filename, l, &filter)
+	case "stats":
+		err = stats(filename, l, &filter)
+	case "verify":
+		err = verify(filename, l)
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}